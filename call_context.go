@@ -0,0 +1,119 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/reusee/sb"
+)
+
+// LuaType is the type of a lua value
+type LuaType int
+
+const (
+	TypeNone          LuaType = C.LUA_TNONE
+	TypeNil           LuaType = C.LUA_TNIL
+	TypeBoolean       LuaType = C.LUA_TBOOLEAN
+	TypeLightUserdata LuaType = C.LUA_TLIGHTUSERDATA
+	TypeNumber        LuaType = C.LUA_TNUMBER
+	TypeString        LuaType = C.LUA_TSTRING
+	TypeTable         LuaType = C.LUA_TTABLE
+	TypeFunction      LuaType = C.LUA_TFUNCTION
+	TypeUserdata      LuaType = C.LUA_TUSERDATA
+	TypeThread        LuaType = C.LUA_TTHREAD
+)
+
+func (t LuaType) String() string {
+	switch t {
+	case TypeNone:
+		return "no value"
+	case TypeNil:
+		return "nil"
+	case TypeBoolean:
+		return "boolean"
+	case TypeLightUserdata, TypeUserdata:
+		return "userdata"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeTable:
+		return "table"
+	case TypeFunction:
+		return "function"
+	case TypeThread:
+		return "thread"
+	}
+	return fmt.Sprintf("lua type %d", int(t))
+}
+
+// CallContext gives direct access to the current call frame of a function registered as func(*CallContext) int.
+// The function returns the number of values pushed as results.
+type CallContext struct {
+	lua *Lua
+	// thread of the call, not the main one inside a coroutine
+	state *C.lua_State
+	argc  int
+}
+
+// Lua returns the lua state of the call
+func (c *CallContext) Lua() *Lua {
+	return c.lua
+}
+
+// ArgCount returns the number of arguments
+func (c *CallContext) ArgCount() int {
+	return c.argc
+}
+
+// ArgType returns the lua type of the i-th argument, starting from 1
+func (c *CallContext) ArgType(i int) LuaType {
+	if i < 1 || i > c.argc {
+		return TypeNone
+	}
+	return LuaType(C.lua_type(c.state, C.int(i)))
+}
+
+// Arg decodes the i-th argument, starting from 1, into target, which must be a pointer
+func (c *CallContext) Arg(i int, target interface{}) error {
+	if i < 1 || i > c.argc {
+		return &LuaError{
+			Kind:    ErrCountMismatch,
+			Message: fmt.Sprintf("no argument #%d", i),
+		}
+	}
+	return argError(c.lua.decode(C.int(i), target), "", i)
+}
+
+// Push pushes v as a result
+func (c *CallContext) Push(v interface{}) {
+	C.lua_checkstack(c.state, 1)
+	ce(sb.Copy(
+		c.lua.marshal(reflect.ValueOf(v)),
+		pushValue(c.lua, nil),
+	))
+}
+
+// Error raises a lua error with the formatted message. Its result must be returned by the function.
+func (c *CallContext) Error(format string, args ...interface{}) int {
+	return c.lua.raiseError(fmt.Errorf(format, args...))
+}
+
+// Context returns the context of the running RunStringContext or CallContext, or context.Background()
+func (c *CallContext) Context() context.Context {
+	return c.lua.context()
+}
+
+// PushObject pushes v as a result wrapped in userdata, see Lua.PushObject
+func (c *CallContext) PushObject(v interface{}) {
+	C.lua_checkstack(c.state, 1)
+	c.lua.PushObject(v)
+}
@@ -0,0 +1,135 @@
+package lgo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCallContext(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("arguments", func(t *testing.T) {
+		lua.RegisterFunction("types", func(c *CallContext) int {
+			if c.ArgCount() != 4 {
+				t.Fatalf("got %d", c.ArgCount())
+			}
+			if c.ArgType(1) != TypeNumber ||
+				c.ArgType(2) != TypeString ||
+				c.ArgType(3) != TypeTable ||
+				c.ArgType(4) != TypeNil ||
+				c.ArgType(5) != TypeNone {
+				t.Fatal()
+			}
+			var i int
+			if err := c.Arg(1, &i); err != nil {
+				t.Fatal(err)
+			}
+			if i != 42 {
+				t.Fatal()
+			}
+			var s string
+			if err := c.Arg(2, &s); err != nil {
+				t.Fatal(err)
+			}
+			if s != "foo" {
+				t.Fatal()
+			}
+			var is []int
+			if err := c.Arg(3, &is); err != nil {
+				t.Fatal(err)
+			}
+			if len(is) != 2 {
+				t.Fatal()
+			}
+			if err := c.Arg(5, &i); !errors.Is(err, ErrCountMismatch) {
+				t.Fatal()
+			}
+			if err := c.Arg(2, &i); !errors.Is(err, ErrTypeMismatch) {
+				t.Fatal()
+			}
+			return 0
+		})
+		lua.RunString(`types(42, 'foo', {1, 2}, nil)`)
+	})
+
+	t.Run("results", func(t *testing.T) {
+		lua.RegisterFunction("results", func(c *CallContext) int {
+			for i := 0; i < c.ArgCount(); i++ {
+				c.Push(i)
+			}
+			return c.ArgCount()
+		})
+		lua.RunString(`
+			if select('#', results()) ~= 0 then error('bad count') end
+			local a, b, c = results(true, true, true)
+			if a ~= 0 or b ~= 1 or c ~= 2 then error('bad results') end
+		`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		lua.RegisterFunction("fail", func(c *CallContext) int {
+			return c.Error("bad %s", "foo")
+		})
+		lua.RunString(`
+			local ok, err = pcall(fail)
+			if ok or err ~= 'bad foo' then error('should fail') end
+		`)
+		err := lua.RunStringE(`fail()`)
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if err.Error() != "bad foo" {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("coroutine", func(t *testing.T) {
+		lua.RegisterFunction("sum", func(c *CallContext) int {
+			ret := 0
+			for i := 1; i <= c.ArgCount(); i++ {
+				if c.ArgType(i) != TypeNumber {
+					return c.Error("bad argument #%d", i)
+				}
+				var n int
+				if err := c.Arg(i, &n); err != nil {
+					return c.Error("%v", err)
+				}
+				ret += n
+			}
+			c.Push(ret)
+			return 1
+		})
+		lua.RegisterFunction("concat", func(a, b string) string {
+			return a + b
+		})
+		lua.RegisterFunction("div", func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("divide by zero")
+			}
+			return a / b, nil
+		})
+		lua.SetGlobalObject("point", &testPoint{X: 1, Y: 2})
+		lua.RunString(`
+			local co = coroutine.wrap(function(a, b)
+				local x = sum(a, b, 3)
+				coroutine.yield(x)
+				coroutine.yield(concat('foo', 'bar'))
+				local ok, err = pcall(div, 1, 0)
+				coroutine.yield(ok, err)
+				local ok, err = pcall(sum, 1, 'x')
+				coroutine.yield(ok, err)
+				point.X = 3
+				return point.X + point:Sum()
+			end)
+			assert(co(1, 2) == 6)
+			assert(co() == 'foobar')
+			local ok, err = co()
+			assert(not ok and err == 'divide by zero')
+			local ok, err = co()
+			assert(not ok and err == 'bad argument #2')
+			assert(co() == 8)
+		`)
+	})
+}
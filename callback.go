@@ -0,0 +1,166 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+
+void setup_message_handler(lua_State*);
+*/
+import "C"
+
+import (
+	"reflect"
+	"runtime"
+	"unsafe"
+)
+
+// callback is a lua function referenced in the registry, called as a go function
+type callback struct {
+	lua      *Lua
+	ref      C.int
+	funcType reflect.Type
+	// funcKey of the go function
+	key uintptr
+}
+
+// newCallback returns a go function of type t calling the lua function at num.
+// The registry reference is released when the go function is garbage collected or passed to ReleaseCallback.
+func (l *Lua) newCallback(num C.int, t reflect.Type) reflect.Value {
+	C.lua_pushvalue(l.State, num)
+	cb := &callback{
+		lua:      l,
+		ref:      C.luaL_ref(l.State, C.LUA_REGISTRYINDEX),
+		funcType: t,
+	}
+	fn := reflect.MakeFunc(t, cb.call)
+	cb.key = funcKey(fn.Interface())
+	l.registerCallback(cb)
+	runtime.SetFinalizer(cb, func(cb *callback) {
+		l := cb.lua
+		l.releaseLock.Lock()
+		defer l.releaseLock.Unlock()
+		// not released by ReleaseCallback or replaced by a newer callback with the same key
+		if l.callbacks[cb.key] == cb {
+			delete(l.callbacks, cb.key)
+			l.released = append(l.released, cb.ref)
+		}
+	})
+	return fn
+}
+
+// registerCallback saves cb by its key.
+// A callback saved with the same key is released, its go function was garbage collected
+// and the closure address reused before the finalizer of the callback ran.
+func (l *Lua) registerCallback(cb *callback) {
+	l.releaseLock.Lock()
+	defer l.releaseLock.Unlock()
+	if old, ok := l.callbacks[cb.key]; ok {
+		l.released = append(l.released, old.ref)
+	}
+	l.callbacks[cb.key] = cb
+}
+
+// funcKey returns the address of the closure of the func value fn, which identifies a function made by reflect.MakeFunc
+func funcKey(fn interface{}) uintptr {
+	return uintptr((*[2]unsafe.Pointer)(unsafe.Pointer(&fn))[1])
+}
+
+// ReleaseCallback releases the registry reference of fn, a go function decoded from a lua function.
+// fn must not be called afterwards. Other values are ignored.
+func (l *Lua) ReleaseCallback(fn interface{}) {
+	l.checkOpen()
+	if reflect.TypeOf(fn) == nil || reflect.TypeOf(fn).Kind() != reflect.Func {
+		return
+	}
+	key := funcKey(fn)
+	l.releaseLock.Lock()
+	cb, ok := l.callbacks[key]
+	delete(l.callbacks, key)
+	l.releaseLock.Unlock()
+	if ok {
+		C.luaL_unref(l.State, C.LUA_REGISTRYINDEX, cb.ref)
+	}
+}
+
+func (c *callback) call(args []reflect.Value) []reflect.Value {
+	l := c.lua
+	l.checkOpen()
+	l.releaseLock.Lock()
+	registered := l.callbacks[c.key]
+	l.releaseLock.Unlock()
+	if registered != c {
+		l.Panic("callback released")
+	}
+	t := c.funcType
+
+	numOut := t.NumOut()
+	returnsError := numOut > 0 && t.Out(numOut-1) == errorType
+	if returnsError {
+		numOut--
+	}
+	results := make([]reflect.Value, 0, t.NumOut())
+	for i := 0; i < numOut; i++ {
+		results = append(results, reflect.New(t.Out(i)).Elem())
+	}
+	fail := func(err error) []reflect.Value {
+		if !returnsError {
+			panic(err)
+		}
+		return append(results, reflect.ValueOf(&err).Elem())
+	}
+
+	if t.IsVariadic() {
+		last := args[len(args)-1]
+		args = args[:len(args)-1]
+		for i := 0; i < last.Len(); i++ {
+			args = append(args, last.Index(i))
+		}
+	}
+
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, C.int(len(args)+2))
+	C.setup_message_handler(l.State)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, C.lua_Integer(c.ref))
+	for i, arg := range args {
+		if err := l.pushArg(i+1, arg); err != nil {
+			return fail(err)
+		}
+	}
+	if ret := l.pcall(C.int(len(args)), C.int(numOut), top+1); ret != C.int(0) {
+		return fail(l.newError(ret))
+	}
+	for i := 0; i < numOut; i++ {
+		value := reflect.New(t.Out(i))
+		if err := l.decode(top+C.int(i+2), value.Interface()); err != nil {
+			return fail(err)
+		}
+		results[i] = value.Elem()
+	}
+	if returnsError {
+		results = append(results, reflect.Zero(errorType))
+	}
+	return results
+}
+
+// releaseLater queues a registry reference for releasing, it is safe to call from any goroutine
+func (l *Lua) releaseLater(ref C.int) {
+	l.releaseLock.Lock()
+	l.released = append(l.released, ref)
+	l.releaseLock.Unlock()
+}
+
+// release releases the queued registry references
+func (l *Lua) release() {
+	l.releaseLock.Lock()
+	refs := l.released
+	l.released = nil
+	l.releaseLock.Unlock()
+	if l.State == nil {
+		return
+	}
+	for _, ref := range refs {
+		C.luaL_unref(l.State, C.LUA_REGISTRYINDEX, ref)
+	}
+}
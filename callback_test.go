@@ -0,0 +1,221 @@
+package lgo
+
+import (
+	"errors"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallback(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("map", func(t *testing.T) {
+		lua.RegisterFunction("mapInts", func(is []int, fn func(int) int) []int {
+			var ret []int
+			for _, i := range is {
+				ret = append(ret, fn(i))
+			}
+			return ret
+		})
+		lua.RunString(`
+			local ret = mapInts({1, 2, 3}, function(i) return i * 2 end)
+			if #ret ~= 3 or ret[1] ~= 2 or ret[3] ~= 6 then error('bad map') end
+		`)
+	})
+
+	t.Run("comparator", func(t *testing.T) {
+		lua.RegisterFunction("sortStrings", func(ss []string, less func(a, b string) bool) []string {
+			sort.Slice(ss, func(i, j int) bool {
+				return less(ss[i], ss[j])
+			})
+			return ss
+		})
+		lua.RunString(`
+			local ret = sortStrings({'b', 'c', 'a'}, function(a, b) return a > b end)
+			if ret[1] ~= 'c' or ret[2] ~= 'b' or ret[3] ~= 'a' then error('bad sort') end
+		`)
+	})
+
+	t.Run("stored", func(t *testing.T) {
+		var handler func(string) (string, error)
+		lua.RegisterFunction("onEvent", func(fn func(string) (string, error)) {
+			handler = fn
+		})
+		lua.RunString(`
+			onEvent(function(ev)
+				if ev == 'bad' then error('bad event') end
+				return 'handled ' .. ev
+			end)
+		`)
+		ret, err := handler("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret != "handled foo" {
+			t.Fatalf("got %s", ret)
+		}
+		_, err = handler("bad")
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(err.Error(), "bad event") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("variadic", func(t *testing.T) {
+		var join func(...string) string
+		lua.RegisterFunction("setJoin", func(fn func(...string) string) {
+			join = fn
+		})
+		lua.RunString(`
+			setJoin(function(...) return table.concat({...}, ',') end)
+		`)
+		if ret := join("a", "b", "c"); ret != "a,b,c" {
+			t.Fatalf("got %s", ret)
+		}
+	})
+
+	t.Run("error in callback", func(t *testing.T) {
+		lua.RegisterFunction("callIt", func(fn func()) {
+			fn()
+		})
+		err := lua.RunStringE(`callIt(function() error('foo') end)`)
+		if err == nil {
+			t.Fatal()
+		}
+		lua.RunString(`
+			local ok = pcall(callIt, function() error('foo') end)
+			if ok then error('should fail') end
+		`)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		var fn func() int
+		lua.RegisterFunction("setFn", func(f func() int) {
+			fn = f
+		})
+		callbackRef := func(fn interface{}) (int, bool) {
+			lua.releaseLock.Lock()
+			defer lua.releaseLock.Unlock()
+			cb, ok := lua.callbacks[funcKey(fn)]
+			if !ok {
+				return 0, false
+			}
+			return int(cb.ref), true
+		}
+
+		// explicitly
+		lua.RunString(`setFn(function() return 1 end)`)
+		if fn() != 1 {
+			t.Fatal()
+		}
+		if _, ok := callbackRef(fn); !ok {
+			t.Fatal()
+		}
+		lua.ReleaseCallback(fn)
+		if _, ok := callbackRef(fn); ok {
+			t.Fatal()
+		}
+		lua.ReleaseCallback(fn) // no-op
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Fatal()
+				}
+			}()
+			fn()
+		}()
+
+		// garbage collected
+		lua.RunString(`setFn(function() return 2 end)`)
+		ref, ok := callbackRef(fn)
+		if !ok {
+			t.Fatal()
+		}
+		fn = nil
+		isReleased := func() bool {
+			lua.releaseLock.Lock()
+			defer lua.releaseLock.Unlock()
+			for _, r := range lua.released {
+				if int(r) == ref {
+					return true
+				}
+			}
+			return false
+		}
+		for i := 0; i < 100 && !isReleased(); i++ {
+			runtime.GC()
+			time.Sleep(time.Millisecond * 10)
+		}
+		if !isReleased() {
+			t.Fatal("not released")
+		}
+		lua.RunString(`local a = 1`)
+		if isReleased() {
+			t.Fatal("not unreferenced")
+		}
+	})
+
+	t.Run("reused key", func(t *testing.T) {
+		var fns []func() int
+		lua.RegisterFunction("addFn", func(f func() int) {
+			fns = append(fns, f)
+		})
+		lua.RunString(`
+			addFn(function() return 1 end)
+			addFn(function() return 2 end)
+		`)
+		lua.releaseLock.Lock()
+		old := lua.callbacks[funcKey(fns[0])]
+		cb := lua.callbacks[funcKey(fns[1])]
+		lua.releaseLock.Unlock()
+
+		// as if the first function was collected and its closure address reused by the second
+		lua.releaseLock.Lock()
+		delete(lua.callbacks, cb.key)
+		lua.releaseLock.Unlock()
+		cb.key = old.key
+		lua.registerCallback(cb)
+
+		lua.releaseLock.Lock()
+		released := len(lua.released) > 0 && lua.released[len(lua.released)-1] == old.ref
+		lua.releaseLock.Unlock()
+		if !released {
+			t.Fatal("replaced callback not released")
+		}
+		if fns[1]() != 2 {
+			t.Fatal()
+		}
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Fatal()
+				}
+			}()
+			fns[0]()
+		}()
+		lua.RunString(`local a = 1`)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		lua := New()
+		var fn func()
+		lua.RegisterFunction("set", func(f func()) {
+			fn = f
+		})
+		lua.RunString(`set(function() end)`)
+		lua.Close()
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal()
+			}
+		}()
+		fn()
+	})
+}
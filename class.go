@@ -0,0 +1,111 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+#include <stdint.h>
+
+void register_function(lua_State*, const char*, int64_t);
+void set_call_metatable(lua_State*);
+void push_function(lua_State*, int64_t);
+void set_metamethod(lua_State*, const char*, int64_t, int);
+*/
+import "C"
+
+import (
+	"reflect"
+)
+
+// TypeOption configures a registered type
+type TypeOption func(*class)
+
+type class struct {
+	constructor interface{}
+}
+
+// Constructor sets the go function called when the class table is called.
+// Returned values of the registered type are pushed as objects.
+func Constructor(fn interface{}) TypeOption {
+	return func(c *class) {
+		c.constructor = fn
+	}
+}
+
+// RegisterType creates a class table for the type of value at name, which may be dotted like in RegisterFunction.
+// The class table contains a "new" function creating an object from an optional table,
+// and all methods of the type taking the object as the first argument.
+// Values of the type returned from go functions are pushed as objects.
+func (l *Lua) RegisterType(name string, value interface{}, options ...TypeOption) {
+	l.checkOpen()
+	t := reflect.TypeOf(value)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	c := new(class)
+	for _, option := range options {
+		option(c)
+	}
+	l.types[t] = c
+
+	name = l.pushNamespace(name)
+	C.lua_pushstring(l.State, cstr(name))
+	C.lua_createtable(l.State, 0, 0)
+
+	// new
+	newFunc := l.newFunction(name+".new", func(call *CallContext) int {
+		ptr := reflect.New(t)
+		if call.ArgType(1) != TypeNone && call.ArgType(1) != TypeNil {
+			if err := call.Arg(1, ptr.Interface()); err != nil {
+				return call.lua.raiseError(err)
+			}
+		}
+		call.lua.pushObject(ptr)
+		return 1
+	})
+	C.register_function(l.State, cstr("new"), C.int64_t(l.newHandle(newFunc)))
+
+	// methods
+	ptrType := reflect.PtrTo(t)
+	for i := 0; i < ptrType.NumMethod(); i++ {
+		methodName := ptrType.Method(i).Name
+		handle, _ := l.method(ptrType, methodName)
+		C.register_function(l.State, cstr(methodName), C.int64_t(handle))
+	}
+
+	// constructor
+	if c.constructor != nil {
+		ctor := l.newFunction(name, c.constructor)
+		C.push_function(l.State, C.int64_t(l.newHandle(ctor)))
+		C.set_call_metatable(l.State)
+	}
+
+	C.lua_rawset(l.State, -3)
+	C.lua_settop(l.State, -2)
+}
+
+// setMetamethods maps conventional go methods of t to metamethods of the metatable at the top
+func (l *Lua) setMetamethods(t reflect.Type) {
+	for _, mapping := range []struct {
+		method     string
+		metamethod string
+		numIn      int
+		firstOnly  bool
+	}{
+		{"String", "__tostring", 1, false},
+		{"Equal", "__eq", 2, false},
+		{"Less", "__lt", 2, false},
+		{"Len", "__len", 1, true},
+	} {
+		method, ok := t.MethodByName(mapping.method)
+		if !ok || method.Type.NumIn() != mapping.numIn || method.Type.NumOut() != 1 {
+			continue
+		}
+		handle, _ := l.method(t, mapping.method)
+		var firstOnly C.int
+		if mapping.firstOnly {
+			firstOnly = 1
+		}
+		C.set_metamethod(l.State, cstr(mapping.metamethod), C.int64_t(handle), firstOnly)
+	}
+}
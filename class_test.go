@@ -0,0 +1,89 @@
+package lgo
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testVec struct {
+	X, Y int
+}
+
+func (v testVec) String() string {
+	return fmt.Sprintf("(%d, %d)", v.X, v.Y)
+}
+
+func (v testVec) Equal(o testVec) bool {
+	return v == o
+}
+
+func (v testVec) Less(o testVec) bool {
+	return v.X < o.X
+}
+
+func (v testVec) Len() int {
+	return v.X + v.Y
+}
+
+func (v *testVec) Add(o *testVec) *testVec {
+	return &testVec{
+		X: v.X + o.X,
+		Y: v.Y + o.Y,
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RegisterType("geo.Vec", testVec{}, Constructor(func(x, y int) testVec {
+		return testVec{X: x, Y: y}
+	}))
+	lua.RegisterFunction("origin", func() *testVec {
+		return &testVec{}
+	})
+
+	t.Run("constructors", func(t *testing.T) {
+		lua.RunString(`
+			local a = geo.Vec.new{X = 1, Y = 2}
+			if a.X ~= 1 or a.Y ~= 2 then error('new') end
+			local b = geo.Vec(1, 2)
+			if b.X ~= 1 or b.Y ~= 2 then error('constructor') end
+			local c = geo.Vec.new()
+			if c.X ~= 0 then error('empty new') end
+		`)
+	})
+
+	t.Run("methods", func(t *testing.T) {
+		lua.RunString(`
+			local a = geo.Vec(1, 2)
+			local b = a:Add(geo.Vec(3, 4))
+			if b.X ~= 4 or b.Y ~= 6 then error('method') end
+			if geo.Vec.Len(b) ~= 10 then error('class method') end
+			local o = origin()
+			if o:Len() ~= 0 then error('returned object') end
+		`)
+	})
+
+	t.Run("metamethods", func(t *testing.T) {
+		lua.RunString(`
+			local a = geo.Vec(1, 2)
+			if tostring(a) ~= '(1, 2)' then error('__tostring') end
+			if a ~= geo.Vec(1, 2) then error('__eq') end
+			if a == geo.Vec(2, 2) then error('__eq') end
+			if not (a < geo.Vec(2, 2)) then error('__lt') end
+			if #a ~= 3 then error('__len') end
+		`)
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		var vec testVec
+		lua.RegisterFunction("takeVec", func(v testVec) {
+			vec = v
+		})
+		lua.RunString(`takeVec(geo.Vec(5, 6))`)
+		if vec.X != 5 || vec.Y != 6 {
+			t.Fatal()
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package lgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// RunStringContext runs code like RunStringE, aborting when ctx is done.
+// The error of an aborted run is a *LuaError of ErrCanceled wrapping ctx.Err().
+func (l *Lua) RunStringContext(ctx context.Context, code string) error {
+	return l.withContext(ctx, func() error {
+		return l.RunStringE(code)
+	})
+}
+
+// CallContext calls the global function name like Call, aborting when ctx is done.
+// The error of an aborted call is a *LuaError of ErrCanceled wrapping ctx.Err().
+func (l *Lua) CallContext(ctx context.Context, name string, args []interface{}, rets ...interface{}) error {
+	return l.withContext(ctx, func() error {
+		return l.Call(name, args, rets...)
+	})
+}
+
+// withContext runs fn with ctx checked by the hook
+func (l *Lua) withContext(ctx context.Context, fn func() error) error {
+	if l.State == nil {
+		return errClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return &LuaError{
+			Kind:    ErrCanceled,
+			Message: err.Error(),
+			Err:     err,
+		}
+	}
+	prev := l.ctx
+	l.ctx = ctx
+	defer func() {
+		l.ctx = prev
+	}()
+	err := fn()
+	var luaErr *LuaError
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) && errors.As(err, &luaErr) {
+		luaErr.Kind = ErrCanceled
+	}
+	return err
+}
+
+// context returns the context of the running call, or context.Background()
+func (l *Lua) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
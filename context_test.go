@@ -0,0 +1,81 @@
+package lgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		err := lua.RunStringContext(ctx, `
+			while true do
+				pcall(function()
+					while true do end
+				end)
+			end
+		`)
+		if !errors.Is(err, ErrCanceled) {
+			t.Fatalf("got %v", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := lua.RunStringContext(ctx, `x = 1`)
+		if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("go function", func(t *testing.T) {
+		type key struct{}
+		ctx := context.WithValue(context.Background(), key{}, "foo")
+		lua.RegisterFunction("value", func(ctx context.Context, i int) string {
+			v, _ := ctx.Value(key{}).(string)
+			return v + string(rune('0'+i))
+		})
+		if err := lua.RunStringContext(ctx, `assert(value(1) == 'foo1')`); err != nil {
+			t.Fatal(err)
+		}
+		lua.RunString(`assert(value(2) == '2')`)
+	})
+
+	t.Run("call", func(t *testing.T) {
+		lua.RunString(`
+			function add(a, b)
+				return a + b
+			end
+			function loop()
+				while true do end
+			end
+		`)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		var ret int
+		if err := lua.CallContext(ctx, "add", []interface{}{1, 2}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != 3 {
+			t.Fatal()
+		}
+		if err := lua.CallContext(ctx, "loop", nil); !errors.Is(err, ErrCanceled) {
+			t.Fatalf("got %v", err)
+		}
+		// hook removed
+		if err := lua.Call("add", []interface{}{1, 2}, &ret); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package lgo
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Converter converts values of a go type to and from values of simpler types, which are pushed and decoded as usual
+type Converter struct {
+	// ToLua returns the value pushed in place of value. A nil ToLua leaves pushing as is.
+	ToLua func(value reflect.Value) (interface{}, error)
+	// FromLua returns the value of type t converted from the lua value decoded as interface{}.
+	// It is not called for nil. A nil FromLua leaves decoding as is.
+	FromLua func(value interface{}, t reflect.Type) (reflect.Value, error)
+}
+
+// RegisterConverter registers the converter for values of type t, replacing the built-in one if any.
+// Built-in converters handle time.Time, time.Duration and types implementing encoding.TextMarshaler or encoding.TextUnmarshaler.
+func (l *Lua) RegisterConverter(t reflect.Type, converter Converter) {
+	l.converters[t] = &converter
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// converter returns the converter of type t, or nil if there is none
+func (l *Lua) converter(t reflect.Type) *Converter {
+	if converter, ok := l.converters[t]; ok {
+		return converter
+	}
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+	if t.Kind() == reflect.Ptr {
+		// dereferenced when marshaling and decoding, the element type is converted
+		return nil
+	}
+	var converter Converter
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		converter.ToLua = marshalText
+	}
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		converter.FromLua = unmarshalText
+	}
+	if converter.ToLua == nil && converter.FromLua == nil {
+		return nil
+	}
+	return &converter
+}
+
+func (l *Lua) registerBuiltinConverters() {
+	l.RegisterConverter(timeType, Converter{
+		ToLua:   l.timeToLua,
+		FromLua: l.timeFromLua,
+	})
+	l.RegisterConverter(durationType, Converter{
+		ToLua:   durationToLua,
+		FromLua: durationFromLua,
+	})
+}
+
+// timeToLua converts a time.Time to Unix seconds, or a string formatted by l.TimeFormat if set
+func (l *Lua) timeToLua(value reflect.Value) (interface{}, error) {
+	t := value.Interface().(time.Time)
+	if l.TimeFormat != "" {
+		return t.Format(l.TimeFormat), nil
+	}
+	if t.Nanosecond() == 0 {
+		return t.Unix(), nil
+	}
+	return float64(t.UnixNano()) / float64(time.Second), nil
+}
+
+// timeFromLua converts Unix seconds, or a string in l.TimeFormat or RFC3339 if not set, to a time.Time
+func (l *Lua) timeFromLua(value interface{}, t reflect.Type) (reflect.Value, error) {
+	switch value := value.(type) {
+	case int64:
+		return reflect.ValueOf(time.Unix(value, 0)), nil
+	case float64:
+		sec, frac := math.Modf(value)
+		return reflect.ValueOf(time.Unix(int64(sec), int64(frac*float64(time.Second)))), nil
+	case string:
+		layout := l.TimeFormat
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		ret, err := time.Parse(layout, value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(ret), nil
+	}
+	return reflect.Value{}, fmt.Errorf("number or string expected for %v", t)
+}
+
+// durationToLua converts a time.Duration to seconds
+func durationToLua(value reflect.Value) (interface{}, error) {
+	d := value.Interface().(time.Duration)
+	if d%time.Second == 0 {
+		return int64(d / time.Second), nil
+	}
+	return d.Seconds(), nil
+}
+
+// durationFromLua converts seconds, or a string like "1m30s", to a time.Duration
+func durationFromLua(value interface{}, t reflect.Type) (reflect.Value, error) {
+	switch value := value.(type) {
+	case int64:
+		return reflect.ValueOf(time.Duration(value) * time.Second), nil
+	case float64:
+		return reflect.ValueOf(time.Duration(value * float64(time.Second))), nil
+	case string:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(d), nil
+	}
+	return reflect.Value{}, fmt.Errorf("number or string expected for %v", t)
+}
+
+func marshalText(value reflect.Value) (interface{}, error) {
+	marshaler, ok := value.Interface().(encoding.TextMarshaler)
+	if !ok {
+		// pointer receiver
+		ptr := reflect.New(value.Type())
+		ptr.Elem().Set(value)
+		marshaler = ptr.Interface().(encoding.TextMarshaler)
+	}
+	text, err := marshaler.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	return string(text), nil
+}
+
+func unmarshalText(value interface{}, t reflect.Type) (reflect.Value, error) {
+	text, ok := value.(string)
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("string expected for %v", t)
+	}
+	ptr := reflect.New(t)
+	if err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); err != nil {
+		return reflect.Value{}, err
+	}
+	return ptr.Elem(), nil
+}
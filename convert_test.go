@@ -0,0 +1,177 @@
+package lgo
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLevel int
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte(strings.Repeat("*", int(l))), nil
+}
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	if strings.Trim(string(text), "*") != "" {
+		return errors.New("bad level")
+	}
+	*l = testLevel(len(text))
+	return nil
+}
+
+func TestConverters(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		function id(v)
+			return v
+		end
+	`)
+
+	t.Run("time", func(t *testing.T) {
+		tm := time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC)
+		lua.SetGlobal("t", tm)
+		lua.RunString(`assert(t == 1633075200)`)
+		var ret time.Time
+		if err := lua.Call("id", []interface{}{tm}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if !ret.Equal(tm) {
+			t.Fatalf("got %v", ret)
+		}
+		// fractional
+		if err := lua.Call("id", []interface{}{1633075200.5}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if !ret.Equal(tm.Add(time.Second / 2)) {
+			t.Fatalf("got %v", ret)
+		}
+		// string
+		if err := lua.Call("id", []interface{}{"2021-10-01T08:00:00Z"}, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if !ret.Equal(tm) {
+			t.Fatalf("got %v", ret)
+		}
+		// pointer pushed like the value
+		lua.SetGlobal("t", &tm)
+		lua.SetGlobal("times", struct {
+			Value   time.Time
+			Pointer *time.Time
+		}{tm, &tm})
+		lua.RunString(`
+			assert(t == 1633075200)
+			assert(times.Value == 1633075200)
+			assert(times.Pointer == 1633075200)
+		`)
+		if err := lua.Call("id", []interface{}{"foo"}, &ret); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		// format
+		lua.TimeFormat = time.RFC3339
+		defer func() {
+			lua.TimeFormat = ""
+		}()
+		lua.SetGlobal("t", tm)
+		lua.RunString(`assert(t == '2021-10-01T08:00:00Z')`)
+		// pointer
+		var ptr *time.Time
+		if err := lua.Call("id", []interface{}{&tm}, &ptr); err != nil {
+			t.Fatal(err)
+		}
+		if ptr == nil || !ptr.Equal(tm) {
+			t.Fatalf("got %v", ptr)
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		lua.SetGlobal("d", 90*time.Second)
+		lua.RunString(`assert(d == 90 and math.type(d) == 'integer')`)
+		lua.SetGlobal("d", 1500*time.Millisecond)
+		lua.RunString(`assert(d == 1.5)`)
+		var d time.Duration
+		for _, v := range []interface{}{90, 90.0, "1m30s"} {
+			if err := lua.Call("id", []interface{}{v}, &d); err != nil {
+				t.Fatal(err)
+			}
+			if d != 90*time.Second {
+				t.Fatalf("got %v", d)
+			}
+		}
+		if err := lua.Call("id", []interface{}{true}, &d); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		lua.SetGlobal("ip", net.ParseIP("127.0.0.1"))
+		lua.SetGlobal("level", testLevel(3))
+		lua.RunString(`
+			assert(ip == '127.0.0.1')
+			assert(level == '***')
+		`)
+		var ip net.IP
+		if err := lua.Call("id", []interface{}{"10.0.0.1"}, &ip); err != nil {
+			t.Fatal(err)
+		}
+		if !ip.Equal(net.ParseIP("10.0.0.1")) {
+			t.Fatalf("got %v", ip)
+		}
+		var level testLevel
+		if err := lua.Call("id", []interface{}{"**"}, &level); err != nil {
+			t.Fatal(err)
+		}
+		if level != 2 {
+			t.Fatal()
+		}
+		if err := lua.Call("id", []interface{}{"foo"}, &level); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("struct fields", func(t *testing.T) {
+		type Job struct {
+			Start   time.Time     `lua:"start"`
+			Timeout time.Duration `lua:"timeout"`
+		}
+		lua.RegisterFunction("job", func(job Job) Job {
+			job.Timeout *= 2
+			return job
+		})
+		lua.RunString(`
+			local job = job({start = 0, timeout = '1m'})
+			assert(job.start == 0)
+			assert(job.timeout == 120)
+		`)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		type Celsius float64
+		lua.RegisterConverter(reflect.TypeOf(Celsius(0)), Converter{
+			ToLua: func(value reflect.Value) (interface{}, error) {
+				return float64(value.Float())*9/5 + 32, nil
+			},
+			FromLua: func(value interface{}, t reflect.Type) (reflect.Value, error) {
+				f, ok := value.(float64)
+				if !ok {
+					return reflect.Value{}, errors.New("float expected")
+				}
+				return reflect.ValueOf(Celsius((f - 32) * 5 / 9)), nil
+			},
+		})
+		lua.SetGlobal("temp", Celsius(100))
+		lua.RunString(`assert(temp == 212)`)
+		var c Celsius
+		if err := lua.GetGlobal("temp", &c); err != nil {
+			t.Fatal(err)
+		}
+		if c != 100 {
+			t.Fatal()
+		}
+	})
+}
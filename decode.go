@@ -2,8 +2,12 @@ package lgo
 
 import (
 	"fmt"
+	"math"
 	"reflect"
-	"sync"
+	"regexp"
+	"strconv"
+	"strings"
+	"unsafe"
 
 	"github.com/reusee/sb"
 )
@@ -15,17 +19,189 @@ import (
 */
 import "C"
 
+// kindValue is the token kind of a go value taken from lua, it is assigned to targets as is
+const kindValue sb.Kind = 250
+
+// decode decodes the value at num into target, which must be a pointer.
+// Errors are *LuaError of ErrTypeMismatch wrapping a *DecodeError.
+func (l *Lua) decode(num C.int, target interface{}) (err error) {
+	depth := len(l.decodePath)
+	defer func() {
+		if p := recover(); p != nil {
+			e, ok := p.(*LuaError)
+			if !ok {
+				l.decodePath = l.decodePath[:depth]
+				panic(p)
+			}
+			err = e
+		}
+		l.decodePath = l.decodePath[:depth]
+	}()
+	proc := decodeStack(l, num, reflect.TypeOf(target), nil)
+	if err := sb.Copy(&proc, l.unmarshal(target)); err != nil {
+		return l.decodeError(C.LUA_TNONE, nil, err.Error())
+	}
+	return nil
+}
+
+// DecodeError is the error of decoding a lua value into a go value of incompatible type
+type DecodeError struct {
+	// Function and Arg are the name and the 1-based argument index of the go function, if decoding an argument
+	Function string
+	Arg      int
+	// Path is the location in nested tables, like .items[3].price
+	Path     string
+	Expected reflect.Type
+	Actual   LuaType
+	// Reason describes the error if it is not a plain type mismatch
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	if e.Path != "" {
+		b.WriteString(e.Path)
+		b.WriteString(": ")
+	}
+	if e.Reason != "" {
+		b.WriteString(e.Reason)
+	} else {
+		fmt.Fprintf(&b, "%v expected, got %v", e.Expected, e.Actual)
+	}
+	switch {
+	case e.Function != "":
+		return fmt.Sprintf("bad argument #%d to '%s' (%s)", e.Arg, e.Function, b.String())
+	case e.Arg > 0:
+		return fmt.Sprintf("arg %d: %s", e.Arg, b.String())
+	}
+	return b.String()
+}
+
+// decodeError returns the error of decoding the value at num into t, located at the current decode path
+func (l *Lua) decodeError(num C.int, t reflect.Type, reason string) *LuaError {
+	e := &DecodeError{
+		Path:     strings.Join(l.decodePath, ""),
+		Expected: t,
+		Actual:   TypeNone,
+		Reason:   reason,
+	}
+	if num != C.LUA_TNONE {
+		e.Actual = LuaType(C.lua_type(l.State, num))
+	}
+	return &LuaError{
+		Kind:    ErrTypeMismatch,
+		Message: e.Error(),
+		Err:     e,
+	}
+}
+
+// argError sets the function name and argument index of the decode error in err
+func argError(err error, function string, arg int) error {
+	if e, ok := err.(*LuaError); ok {
+		if decodeErr, ok := e.Err.(*DecodeError); ok {
+			decodeErr.Function = function
+			decodeErr.Arg = arg
+			e.Message = decodeErr.Error()
+		}
+	}
+	return err
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pushPath appends the key at idx to the decode path
+func (l *Lua) pushPath(idx C.int) {
+	var elem string
+	switch C.lua_type(l.State, idx) {
+	case C.LUA_TSTRING:
+		key := l.toString(idx)
+		if identifierPattern.MatchString(key) {
+			elem = "." + key
+		} else {
+			elem = fmt.Sprintf("[%q]", key)
+		}
+	case C.LUA_TNUMBER:
+		if C.lua_isinteger(l.State, idx) == 1 {
+			elem = fmt.Sprintf("[%d]", int64(C.lua_tointegerx(l.State, idx, nil)))
+		} else {
+			elem = fmt.Sprintf("[%v]", float64(C.lua_tonumberx(l.State, idx, nil)))
+		}
+	default:
+		elem = fmt.Sprintf("[%v]", LuaType(C.lua_type(l.State, idx)))
+	}
+	l.decodePath = append(l.decodePath, elem)
+}
+
+func (l *Lua) popPath() {
+	l.decodePath = l.decodePath[:len(l.decodePath)-1]
+}
+
+// checkStack ensures n free slots for decoding the table at num, nested tables take slots at each level
+func (l *Lua) checkStack(num C.int, n C.int) {
+	if C.lua_checkstack(l.State, n) == 0 {
+		panic(l.decodeError(num, nil, "table nested too deeply"))
+	}
+}
+
 func decodeStack(
 	l *Lua,
 	num C.int,
 	t reflect.Type,
 	cont proc,
 ) proc {
+	isPtr := t.Kind() == reflect.Ptr
 	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
 	return func() (*sb.Token, proc, error) {
+		if t == refType {
+			if !isPtr {
+				panic(l.decodeError(num, t, "lgo.Ref must be decoded as *lgo.Ref"))
+			}
+			return &sb.Token{
+				Kind:  kindValue,
+				Value: reflect.ValueOf(l.newRef(num)),
+			}, cont, nil
+		}
+
+		if t == tableType {
+			switch {
+			case !isPtr:
+				panic(l.decodeError(num, t, "lgo.Table must be decoded as *lgo.Table"))
+			case C.lua_type(l.State, num) == C.LUA_TNIL:
+				return &sb.Token{
+					Kind: sb.KindNil,
+				}, cont, nil
+			case C.lua_type(l.State, num) != C.LUA_TTABLE:
+				panic(l.decodeError(num, tablePtrType, ""))
+			}
+			return &sb.Token{
+				Kind: kindValue,
+				Value: reflect.ValueOf(&Table{
+					Ref: l.newRef(num),
+				}),
+			}, cont, nil
+		}
+
 		luaType := C.lua_type(l.State, num)
+
+		if luaType != C.LUA_TNIL && luaType != C.LUA_TUSERDATA {
+			if converter := l.converter(t); converter != nil && converter.FromLua != nil {
+				var v interface{}
+				if err := l.decode(num, &v); err != nil {
+					panic(err)
+				}
+				value, err := converter.FromLua(v, t)
+				if err != nil {
+					panic(l.decodeError(num, t, err.Error()))
+				}
+				return &sb.Token{
+					Kind:  kindValue,
+					Value: value,
+				}, cont, nil
+			}
+		}
+
 		switch luaType {
 
 		case C.LUA_TNIL:
@@ -34,6 +210,9 @@ func decodeStack(
 			}, cont, nil
 
 		case C.LUA_TBOOLEAN:
+			if t.Kind() != reflect.Bool && t.Kind() != reflect.Interface {
+				panic(l.decodeError(num, t, ""))
+			}
 			return &sb.Token{
 				Kind:  sb.KindBool,
 				Value: C.lua_toboolean(l.State, num) == C.int(1),
@@ -49,62 +228,62 @@ func decodeStack(
 			switch t.Kind() {
 
 			case reflect.Int:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toInt(num, t)
 				return &sb.Token{
 					Kind:  sb.KindInt,
 					Value: int(n),
 				}, cont, nil
 			case reflect.Int8:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toInt(num, t)
 				return &sb.Token{
 					Kind:  sb.KindInt8,
 					Value: int8(n),
 				}, cont, nil
 			case reflect.Int16:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toInt(num, t)
 				return &sb.Token{
 					Kind:  sb.KindInt16,
 					Value: int16(n),
 				}, cont, nil
 			case reflect.Int32:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toInt(num, t)
 				return &sb.Token{
 					Kind:  sb.KindInt32,
 					Value: int32(n),
 				}, cont, nil
 			case reflect.Int64:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toInt(num, t)
 				return &sb.Token{
 					Kind:  sb.KindInt64,
 					Value: int64(n),
 				}, cont, nil
 
 			case reflect.Uint:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toUint(num, t)
 				return &sb.Token{
 					Kind:  sb.KindUint,
 					Value: uint(n),
 				}, cont, nil
 			case reflect.Uint8:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toUint(num, t)
 				return &sb.Token{
 					Kind:  sb.KindUint8,
 					Value: uint8(n),
 				}, cont, nil
 			case reflect.Uint16:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toUint(num, t)
 				return &sb.Token{
 					Kind:  sb.KindUint16,
 					Value: uint16(n),
 				}, cont, nil
 			case reflect.Uint32:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toUint(num, t)
 				return &sb.Token{
 					Kind:  sb.KindUint32,
 					Value: uint32(n),
 				}, cont, nil
 			case reflect.Uint64:
-				n := C.lua_tointegerx(l.State, num, nil)
+				n := l.toUint(num, t)
 				return &sb.Token{
 					Kind:  sb.KindUint64,
 					Value: uint64(n),
@@ -116,7 +295,19 @@ func decodeStack(
 					Kind:  sb.KindFloat32,
 					Value: float32(n),
 				}, cont, nil
-			case reflect.Float64, reflect.Interface:
+			case reflect.Interface:
+				if C.lua_isinteger(l.State, num) == 1 {
+					return &sb.Token{
+						Kind:  sb.KindInt64,
+						Value: int64(C.lua_tointegerx(l.State, num, nil)),
+					}, cont, nil
+				}
+				n := C.lua_tonumberx(l.State, num, nil)
+				return &sb.Token{
+					Kind:  sb.KindFloat64,
+					Value: float64(n),
+				}, cont, nil
+			case reflect.Float64:
 				n := C.lua_tonumberx(l.State, num, nil)
 				return &sb.Token{
 					Kind:  sb.KindFloat64,
@@ -124,22 +315,24 @@ func decodeStack(
 				}, cont, nil
 
 			default:
-				panic(fmt.Errorf("type mismatch, expecting %v", t))
+				panic(l.decodeError(num, t, ""))
 			}
 
 		case C.LUA_TSTRING:
-			str := C.GoString(C.lua_tolstring(l.State, num, nil))
 			if t.Kind() == reflect.Slice &&
 				t.Elem().Kind() == reflect.Uint8 {
 				// []byte
 				return &sb.Token{
 					Kind:  sb.KindBytes,
-					Value: []byte(str),
+					Value: l.toBytes(num),
 				}, cont, nil
 			}
+			if t.Kind() != reflect.String && t.Kind() != reflect.Interface {
+				panic(l.decodeError(num, t, ""))
+			}
 			return &sb.Token{
 				Kind:  sb.KindString,
-				Value: str,
+				Value: l.toString(num),
 			}, cont, nil
 
 		case C.LUA_TTABLE:
@@ -151,27 +344,110 @@ func decodeStack(
 				}, decodeArray(l, num, t, cont), nil
 
 			case reflect.Struct:
-				return &sb.Token{
-					Kind: sb.KindObject,
-				}, decodeObject(l, num, t, cont), nil
+				return decodeObject(l, num, t, cont)()
 
-			case reflect.Map, reflect.Interface:
+			case reflect.Map:
 				return &sb.Token{
 					Kind: sb.KindMap,
 				}, decodeMap(l, num, t, cont), nil
 
+			case reflect.Interface:
+				return decodeInterfaceTable(l, num, cont)
+
 			default:
-				panic(fmt.Errorf("type mismatch, expecting %v", t))
+				panic(l.decodeError(num, t, ""))
 			}
 
+		case C.LUA_TUSERDATA:
+			if o := l.toObject(num); o != nil {
+				return &sb.Token{
+					Kind:  kindValue,
+					Value: o.value,
+				}, cont, nil
+			}
+			panic(l.decodeError(num, t, ""))
+
 		case C.LUA_TFUNCTION:
-			panic("function type not supported")
+			switch {
+			case t.Kind() == reflect.Func:
+				return &sb.Token{
+					Kind:  kindValue,
+					Value: l.newCallback(num, t),
+				}, cont, nil
+			case t.Kind() == reflect.Interface && refPtrType.Implements(t):
+				// referenced, may be called by Ref.Call
+				return &sb.Token{
+					Kind:  kindValue,
+					Value: reflect.ValueOf(l.newRef(num)),
+				}, cont, nil
+			}
+			panic(l.decodeError(num, t, ""))
+
+		default:
+			panic(l.decodeError(num, t, ""))
+		}
 
-		default: // NOCOVER
-			panic(fmt.Errorf("bad lua type: %v", luaType))
+	}
+}
+
+// toString returns the string at num, embedded NUL bytes are preserved
+func (l *Lua) toString(num C.int) string {
+	var length C.size_t
+	p := C.lua_tolstring(l.State, num, &length)
+	return C.GoStringN(p, C.int(length))
+}
+
+// toBytes returns the string at num as a byte slice
+func (l *Lua) toBytes(num C.int) []byte {
+	var length C.size_t
+	p := C.lua_tolstring(l.State, num, &length)
+	return C.GoBytes(unsafe.Pointer(p), C.int(length))
+}
+
+// toInt returns the number at num as an integer of type t, fractional or out of range numbers are errors
+func (l *Lua) toInt(num C.int, t reflect.Type) int64 {
+	var isNum C.int
+	n := int64(C.lua_tointegerx(l.State, num, &isNum))
+	if isNum == 0 {
+		l.numberError(num, t)
+	}
+	if reflect.Zero(t).OverflowInt(n) {
+		l.numberError(num, t)
+	}
+	return n
+}
+
+// toUint returns the number at num as an unsigned integer of type t, fractional, negative or out of range numbers are errors
+func (l *Lua) toUint(num C.int, t reflect.Type) uint64 {
+	var n uint64
+	if C.lua_isinteger(l.State, num) == 1 {
+		i := int64(C.lua_tointegerx(l.State, num, nil))
+		if i < 0 {
+			l.numberError(num, t)
 		}
+		n = uint64(i)
+	} else {
+		// floats beyond the int64 range
+		f := float64(C.lua_tonumberx(l.State, num, nil))
+		if f != math.Trunc(f) || f < 0 || f >= 1<<64 {
+			l.numberError(num, t)
+		}
+		n = uint64(f)
+	}
+	if reflect.Zero(t).OverflowUint(n) {
+		l.numberError(num, t)
+	}
+	return n
+}
 
+func (l *Lua) numberError(num C.int, t reflect.Type) {
+	var value interface{}
+	if C.lua_isinteger(l.State, num) == 1 {
+		value = int64(C.lua_tointegerx(l.State, num, nil))
+	} else {
+		value = float64(C.lua_tonumberx(l.State, num, nil))
 	}
+	panic(l.decodeError(num, t, fmt.Sprintf("number %v cannot be represented as %v", value, t)))
 }
 
 func decodeArray(
@@ -181,6 +457,7 @@ func decodeArray(
 	cont proc,
 ) proc {
 
+	l.checkStack(num, 3)
 	C.lua_pushnil(l.State)
 	elemType := t.Elem()
 
@@ -192,8 +469,10 @@ func decodeArray(
 			}, cont, nil
 		}
 
+		l.pushPath(-2)
 		return decodeStack(l, C.lua_absindex(l.State, -1), elemType,
 			func() (*sb.Token, proc, error) {
+				l.popPath()
 				C.lua_settop(l.State, -2)
 				return nil, ret, nil
 			},
@@ -203,61 +482,54 @@ func decodeArray(
 	return ret
 }
 
+// decodeObject decodes the table at num into a new struct of type t, keys are matched by structFields
 func decodeObject(
 	l *Lua,
 	num C.int,
 	t reflect.Type,
 	cont proc,
 ) proc {
-
-	C.lua_pushnil(l.State)
-
-	var fieldTypes map[string]reflect.Type
-	v, ok := structFieldTypesMap.Load(t)
-	if !ok {
-		fieldTypes = make(map[string]reflect.Type)
-		for i := 0; i < t.NumField(); i++ {
-			field := t.Field(i)
-			fieldTypes[field.Name] = field.Type
-		}
-		structFieldTypesMap.Store(t, fieldTypes)
-	} else {
-		fieldTypes = v.(map[string]reflect.Type)
-	}
-
-	var ret proc
-	ret = func() (*sb.Token, proc, error) {
-		if C.lua_next(l.State, num) == 0 {
-			return &sb.Token{
-				Kind: sb.KindObjectEnd,
-			}, cont, nil
-		}
-
-		name := C.GoString(C.lua_tolstring(l.State, -2, nil))
-		fieldType, ok := fieldTypes[name]
-		if !ok {
-			C.lua_settop(l.State, -2)
-			if l.NonStrict {
-				return nil, ret, nil
+	return func() (*sb.Token, proc, error) {
+		value := reflect.New(t).Elem()
+		fields := l.structFields(t)
+		C.lua_checkstack(l.State, 2)
+		C.lua_pushnil(l.State)
+		for C.lua_next(l.State, num) != 0 {
+			var field *structField
+			var name string
+			if C.lua_type(l.State, -2) == C.LUA_TSTRING {
+				name = l.toString(-2)
+				field = l.lookupField(fields, name)
 			} else {
-				return nil, nil, fmt.Errorf("no %s in %v", name, t)
+				name = fmt.Sprintf("%v key", LuaType(C.lua_type(l.State, -2)))
 			}
+			if field == nil {
+				if !l.NonStrict {
+					err := l.decodeError(num, t, fmt.Sprintf("no field %s in %v", name, t))
+					C.lua_settop(l.State, -3)
+					panic(err)
+				}
+				C.lua_settop(l.State, -2)
+				continue
+			}
+			l.pushPath(-2)
+			if err := l.decode(
+				C.lua_absindex(l.State, -1),
+				value.FieldByIndex(field.index).Addr().Interface(),
+			); err != nil {
+				C.lua_settop(l.State, -3)
+				panic(err)
+			}
+			l.popPath()
+			C.lua_settop(l.State, -2)
 		}
 		return &sb.Token{
-				Kind:  sb.KindString,
-				Value: name,
-			}, decodeStack(l, C.lua_absindex(l.State, -1), fieldType,
-				func() (*sb.Token, proc, error) {
-					C.lua_settop(l.State, -2)
-					return nil, ret, nil
-				},
-			), nil
+			Kind:  kindValue,
+			Value: value,
+		}, cont, nil
 	}
-	return ret
 }
 
-var structFieldTypesMap sync.Map
-
 func decodeMap(
 	l *Lua,
 	num C.int,
@@ -265,6 +537,7 @@ func decodeMap(
 	cont proc,
 ) proc {
 
+	l.checkStack(num, 3)
 	C.lua_pushnil(l.State)
 	keyType := t.Key()
 	elemType := t.Elem()
@@ -277,9 +550,11 @@ func decodeMap(
 			}, cont, nil
 		}
 
+		l.pushPath(-2)
 		return decodeStack(l, C.lua_absindex(l.State, -2), keyType,
 			decodeStack(l, C.lua_absindex(l.State, -1), elemType,
 				func() (*sb.Token, proc, error) {
+					l.popPath()
 					C.lua_settop(l.State, -2)
 					return nil, ret, nil
 				},
@@ -288,3 +563,197 @@ func decodeMap(
 	}
 	return ret
 }
+
+// TableMode is the policy of decoding lua tables into interface{} targets
+type TableMode int
+
+const (
+	// TableAuto decodes sequences as []interface{}, tables with string keys only as map[string]interface{},
+	// and other tables as map[interface{}]interface{}
+	TableAuto TableMode = iota
+	// TableAsMap decodes all tables as map[interface{}]interface{}
+	TableAsMap
+	// TableJSON decodes sequences as []interface{} and other tables as map[string]interface{},
+	// number keys are formatted as strings, and other keys are errors
+	TableJSON
+)
+
+var (
+	genericSliceType = reflect.TypeOf((*[]interface{})(nil)).Elem()
+	genericMapType   = reflect.TypeOf((*map[interface{}]interface{})(nil)).Elem()
+)
+
+// decodeInterfaceTable decodes the table at num for an interface{} target, according to l.TableMode
+func decodeInterfaceTable(
+	l *Lua,
+	num C.int,
+	cont proc,
+) (*sb.Token, proc, error) {
+	l.checkStack(num, 3)
+	n, isSequence, stringKeys := tableShape(l, num)
+
+	if l.TableMode != TableAsMap && isSequence {
+		return &sb.Token{
+			Kind: sb.KindArray,
+		}, decodeSequence(l, num, n, genericSliceType.Elem(), cont), nil
+	}
+
+	if l.TableMode == TableJSON || l.TableMode == TableAuto && stringKeys {
+		return &sb.Token{
+			Kind:  kindValue,
+			Value: reflect.ValueOf(decodeStringMap(l, num)),
+		}, cont, nil
+	}
+
+	return &sb.Token{
+		Kind: sb.KindMap,
+	}, decodeMap(l, num, genericMapType, cont), nil
+}
+
+// tableShape returns the number of pairs of the table at num,
+// whether the keys are 1 to n, and whether all keys are strings
+func tableShape(l *Lua, num C.int) (n int, isSequence bool, stringKeys bool) {
+	isSequence = true
+	stringKeys = true
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, num) != 0 {
+		n++
+		if C.lua_type(l.State, -2) != C.LUA_TSTRING {
+			stringKeys = false
+		}
+		if C.lua_isinteger(l.State, -2) == 0 {
+			isSequence = false
+		}
+		C.lua_settop(l.State, -2)
+	}
+	if isSequence && n > 0 {
+		// integer keys are distinct, so they are 1 to n if all are in the range
+		C.lua_pushnil(l.State)
+		for C.lua_next(l.State, num) != 0 {
+			i := int64(C.lua_tointegerx(l.State, -2, nil))
+			C.lua_settop(l.State, -2)
+			if i < 1 || i > int64(n) {
+				isSequence = false
+				C.lua_settop(l.State, -2)
+				break
+			}
+		}
+	}
+	isSequence = isSequence && n > 0
+	return
+}
+
+// decodeSequence decodes the elements 1 to n of the table at num as an array
+func decodeSequence(
+	l *Lua,
+	num C.int,
+	n int,
+	elemType reflect.Type,
+	cont proc,
+) proc {
+	i := 0
+	var ret proc
+	ret = func() (*sb.Token, proc, error) {
+		if i == n {
+			return &sb.Token{
+				Kind: sb.KindArrayEnd,
+			}, cont, nil
+		}
+		i++
+		l.checkStack(num, 3)
+		C.lua_rawgeti(l.State, num, C.lua_Integer(i))
+		l.decodePath = append(l.decodePath, fmt.Sprintf("[%d]", i))
+		return decodeStack(l, C.lua_absindex(l.State, -1), elemType,
+			func() (*sb.Token, proc, error) {
+				l.popPath()
+				C.lua_settop(l.State, -2)
+				return nil, ret, nil
+			},
+		)()
+	}
+	return ret
+}
+
+// decodeStringMap decodes the table at num as a map[string]interface{}, number keys are formatted
+func decodeStringMap(l *Lua, num C.int) map[string]interface{} {
+	m := make(map[string]interface{})
+	C.lua_checkstack(l.State, 2)
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, num) != 0 {
+		var key string
+		switch C.lua_type(l.State, -2) {
+		case C.LUA_TSTRING:
+			key = l.toString(-2)
+		case C.LUA_TNUMBER:
+			if C.lua_isinteger(l.State, -2) == 1 {
+				key = strconv.FormatInt(int64(C.lua_tointegerx(l.State, -2, nil)), 10)
+			} else {
+				key = strconv.FormatFloat(float64(C.lua_tonumberx(l.State, -2, nil)), 'g', -1, 64)
+			}
+		default:
+			err := l.decodeError(num, nil, fmt.Sprintf("string key expected, got %v", LuaType(C.lua_type(l.State, -2))))
+			C.lua_settop(l.State, -3)
+			panic(err)
+		}
+		l.pushPath(-2)
+		var value interface{}
+		if err := l.decode(C.lua_absindex(l.State, -1), &value); err != nil {
+			panic(err)
+		}
+		l.popPath()
+		m[key] = value
+		C.lua_settop(l.State, -2)
+	}
+	return m
+}
+
+// unmarshalValue is the sb unmarshal function used for decoding, it assigns values taken from objects.
+// nil is decoded as the nil value of pointers, interfaces, maps and slices, even if the target is set.
+func (l *Lua) unmarshalValue(ctx sb.Ctx, target reflect.Value, cont sink) sink {
+	return func(token *sb.Token) (sink, error) {
+		if token != nil && token.Kind == sb.KindNil {
+			switch target.Elem().Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+				target.Elem().Set(reflect.Zero(target.Elem().Type()))
+				return cont, nil
+			}
+		}
+		if token != nil && token.Kind == kindValue {
+			if err := assignValue(target.Elem(), token.Value.(reflect.Value)); err != nil {
+				return nil, err
+			}
+			return cont, nil
+		}
+		return sb.UnmarshalValue(ctx, target, cont)(token)
+	}
+}
+
+func (l *Lua) unmarshal(target interface{}) sink {
+	return l.unmarshalValue(
+		sb.Ctx{
+			Unmarshal: l.unmarshalValue,
+		},
+		reflect.ValueOf(target),
+		nil,
+	)
+}
+
+// assignValue sets target to value, dereferencing or allocating pointers as needed
+func assignValue(target reflect.Value, value reflect.Value) error {
+	t := target.Type()
+	switch {
+	case value.Type().AssignableTo(t):
+		target.Set(value)
+	case value.Kind() == reflect.Ptr && value.Elem().Type().AssignableTo(t):
+		target.Set(value.Elem())
+	case t.Kind() == reflect.Ptr:
+		ptr := reflect.New(t.Elem())
+		if err := assignValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		target.Set(ptr)
+	default:
+		return fmt.Errorf("%v expected, got %v", t, value.Type())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+
+void setup_env(lua_State*);
+*/
+import "C"
+
+import "reflect"
+
+// Env is a table of globals for scripts, created by NewEnv.
+// Globals missing from an Env are read from the global table shared by all environments,
+// which holds the standard libraries and registered functions.
+// Assignments to globals are kept in the Env, so scripts run in different environments
+// cannot see or clobber each other's globals.
+// Chunks loaded by load default to the Env too.
+// Tables reached from the shared globals, like string, are not copied,
+// so changes to their fields are seen by every environment; an Env is not a sandbox.
+type Env struct {
+	*Table
+}
+
+var envPtrType = reflect.TypeOf((*Env)(nil))
+
+// NewEnv creates an environment inheriting the globals.
+// The _G of the environment is the environment itself.
+func (l *Lua) NewEnv() *Env {
+	l.checkOpen()
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 3)
+	C.lua_createtable(l.State, 0, 1)
+	C.lua_pushvalue(l.State, -1)
+	C.lua_setfield(l.State, -2, cstr("_G"))
+	C.lua_createtable(l.State, 0, 2)
+	C.lua_rawgeti(l.State, C.LUA_REGISTRYINDEX, C.LUA_RIDX_GLOBALS)
+	C.lua_setfield(l.State, -2, cstr("__index"))
+	// hides the global table from getmetatable
+	C.lua_pushboolean(l.State, 0)
+	C.lua_setfield(l.State, -2, cstr("__metatable"))
+	C.lua_setmetatable(l.State, -2)
+	C.setup_env(l.State)
+	return &Env{
+		Table: &Table{
+			Ref: l.newRef(-1),
+		},
+	}
+}
+
+// RunStringIn runs code like RunStringE, with env as its global table
+func (l *Lua) RunStringIn(env *Env, code string) error {
+	if err := l.checkEnv(env); err != nil {
+		return err
+	}
+	return l.runString(code, env)
+}
+
+// CallIn calls the function name of env like Call.
+// Functions defined by RunStringIn keep env as their global table.
+func (l *Lua) CallIn(env *Env, name string, args []interface{}, rets ...interface{}) error {
+	if err := l.checkEnv(env); err != nil {
+		return err
+	}
+	return l.call(func() {
+		env.Push()
+		C.lua_getfield(l.State, -1, cstr(name))
+		C.lua_copy(l.State, -1, -2)
+		C.lua_settop(l.State, -2)
+	}, args, rets)
+}
+
+// checkEnv returns a *LuaError of ErrTypeMismatch if env is nil or of another lua state
+func (l *Lua) checkEnv(env *Env) error {
+	if env == nil || env.Table == nil {
+		return &LuaError{Kind: ErrTypeMismatch, Message: "nil env"}
+	}
+	if env.lua != l {
+		return &LuaError{Kind: ErrTypeMismatch, Message: "env of another lua state"}
+	}
+	return nil
+}
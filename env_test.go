@@ -0,0 +1,141 @@
+package lgo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEnv(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RegisterFunction("double", func(i int) int {
+		return i * 2
+	})
+	lua.RunString(`shared = 'shared'`)
+
+	env1 := lua.NewEnv()
+	defer env1.Release()
+	env2 := lua.NewEnv()
+	defer env2.Release()
+
+	t.Run("isolation", func(t *testing.T) {
+		if err := lua.RunStringIn(env1, `
+			x = 1
+			function get()
+				return x, double(x), shared
+			end
+		`); err != nil {
+			t.Fatal(err)
+		}
+		if err := lua.RunStringIn(env2, `
+			x = 2
+			shared = 'clobbered'
+			_G.y = 3
+			function get()
+				return x, double(x), shared
+			end
+		`); err != nil {
+			t.Fatal(err)
+		}
+
+		var x, d int
+		var s string
+		if err := lua.CallIn(env1, "get", nil, &x, &d, &s); err != nil {
+			t.Fatal(err)
+		}
+		if x != 1 || d != 2 || s != "shared" {
+			t.Fatalf("got %d %d %s", x, d, s)
+		}
+		if err := lua.CallIn(env2, "get", nil, &x, &d, &s); err != nil {
+			t.Fatal(err)
+		}
+		if x != 2 || d != 4 || s != "clobbered" {
+			t.Fatalf("got %d %d %s", x, d, s)
+		}
+
+		// globals untouched
+		lua.RunString(`
+			assert(x == nil)
+			assert(y == nil)
+			assert(get == nil)
+			assert(shared == 'shared')
+		`)
+		if err := env2.RawGet("y", &x); err != nil {
+			t.Fatal(err)
+		}
+		if x != 3 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("load", func(t *testing.T) {
+		if err := lua.RunStringIn(env1, `
+			load("z = 1; shared = 'loaded'")()
+			assert(z == 1 and shared == 'loaded')
+			local env = {}
+			load("z = 2", "chunk", "t", env)()
+			assert(env.z == 2 and z == 1)
+		`); err != nil {
+			t.Fatal(err)
+		}
+		lua.RunString(`
+			assert(z == nil)
+			assert(shared == 'shared')
+		`)
+	})
+
+	t.Run("shared tables", func(t *testing.T) {
+		// documented, fields of library tables are not isolated
+		if err := lua.RunStringIn(env1, `string.envtest = 1`); err != nil {
+			t.Fatal(err)
+		}
+		lua.RunString(`
+			assert(string.envtest == 1)
+			string.envtest = nil
+		`)
+	})
+
+	t.Run("metatable hidden", func(t *testing.T) {
+		if err := lua.RunStringIn(env1, `
+			assert(getmetatable(_G) == false)
+			assert(not pcall(setmetatable, _G, nil))
+		`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if err := lua.CallIn(env1, "foo", nil); !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.RunStringIn(env1, `error('foo')`); !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.RunStringIn(env1, `(`); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.RunStringIn(nil, `x = 1`); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.CallIn(nil, "get", nil); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		other := New()
+		defer other.Close()
+		if err := other.RunStringIn(env1, `x = 1`); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		if err := other.CallIn(env1, "get", nil); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("push", func(t *testing.T) {
+		lua.SetGlobal("env", env1)
+		lua.RunString(`
+			assert(env.x == 1)
+			env = nil
+		`)
+	})
+}
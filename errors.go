@@ -0,0 +1,182 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+)
+
+// ErrorKind classifies a LuaError. It implements error, so a kind can be
+// matched with errors.Is.
+type ErrorKind int
+
+const (
+	ErrRuntime ErrorKind = iota + 1
+	ErrSyntax
+	ErrMemory
+	ErrMessageHandler
+	ErrTypeMismatch
+	ErrCountMismatch
+	ErrClosed
+	ErrCanceled
+	ErrLimit
+)
+
+func (k ErrorKind) Error() string {
+	switch k {
+	case ErrRuntime:
+		return "runtime error"
+	case ErrSyntax:
+		return "syntax error"
+	case ErrMemory:
+		return "memory error"
+	case ErrMessageHandler:
+		return "message handler error"
+	case ErrTypeMismatch:
+		return "type mismatch"
+	case ErrCountMismatch:
+		return "count mismatch"
+	case ErrClosed:
+		return "lua state closed"
+	case ErrCanceled:
+		return "canceled"
+	case ErrLimit:
+		return "limit exceeded"
+	}
+	return fmt.Sprintf("error kind %d", int(k))
+}
+
+// LuaError is the error returned by the E variants of running functions.
+type LuaError struct {
+	Kind      ErrorKind
+	Message   string
+	Traceback string
+	// Chunk and Line are parsed from the position prefix of Message, if any
+	Chunk string
+	Line  int
+	// Err is the go error returned by a registered function, if the lua error was raised from it
+	Err error
+}
+
+var _ error = new(LuaError)
+
+func (e *LuaError) Error() string {
+	return e.Message
+}
+
+func (e *LuaError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LuaError) Is(target error) bool {
+	kind, ok := target.(ErrorKind)
+	return ok && kind == e.Kind
+}
+
+var errClosed = &LuaError{
+	Kind:    ErrClosed,
+	Message: ErrClosed.Error(),
+}
+
+var positionPattern = regexp.MustCompile(`^(\[string ".*?"\]|[^:\s]+):([0-9]+): `)
+
+// newError builds a LuaError from the error object at the top of the stack
+func (l *Lua) newError(ret C.int) *LuaError {
+	err := &LuaError{}
+	switch ret {
+	case C.LUA_ERRSYNTAX:
+		err.Kind = ErrSyntax
+	case C.LUA_ERRMEM:
+		err.Kind = ErrMemory
+	case C.LUA_ERRERR:
+		err.Kind = ErrMessageHandler
+	default:
+		err.Kind = ErrRuntime
+	}
+
+	switch C.lua_type(l.State, -1) {
+	case C.LUA_TSTRING, C.LUA_TNUMBER:
+		err.Message = l.toString(-1)
+	default:
+		err.Message = fmt.Sprintf(
+			"(error object is a %s value)",
+			C.GoString(C.lua_typename(l.State, C.lua_type(l.State, -1))),
+		)
+	}
+	if err.Message == l.raisedMessage && l.raisedError != nil {
+		err.Err = l.raisedError
+		if kind, ok := l.raisedError.(ErrorKind); ok {
+			err.Kind = kind
+		}
+	}
+	l.clearRaised()
+	if match := positionPattern.FindStringSubmatch(err.Message); match != nil {
+		err.Chunk = match[1]
+		err.Line, _ = strconv.Atoi(match[2])
+	}
+
+	// traceback saved by the message handler
+	C.lua_getfield(l.State, C.LUA_REGISTRYINDEX, cstr(tracebackKey))
+	if C.lua_type(l.State, -1) == C.LUA_TSTRING {
+		err.Traceback = l.toString(-1)
+	}
+	C.lua_settop(l.State, -2)
+	C.lua_pushnil(l.State)
+	C.lua_setfield(l.State, C.LUA_REGISTRYINDEX, cstr(tracebackKey))
+
+	return err
+}
+
+const tracebackKey = "lgo.traceback"
+
+// clearRaised forgets the go error last raised
+func (l *Lua) clearRaised() {
+	l.raisedError = nil
+	l.raisedMessage = ""
+}
+
+// raiseError pushes the message of err as the error object.
+// The result must be returned to invoke_go_func, which raises the lua error.
+func (l *Lua) raiseError(err error) int {
+	msg := err.Error()
+	l.raisedError = err
+	l.raisedMessage = msg
+	C.lua_checkstack(l.State, 1)
+	l.pushString(msg)
+	return -1
+}
+
+// raiseRecovered raises the value recovered in a go function called from lua.
+// A *LuaError is raised as lua error, other values as go panic.
+func (l *Lua) raiseRecovered(p interface{}) int {
+	if err, ok := p.(*LuaError); ok {
+		return l.raiseError(err)
+	}
+	return l.raisePanic(p)
+}
+
+type goPanic struct {
+	value   interface{}
+	message string
+}
+
+// raisePanic records the go panic p and pushes its message and the go stack as the error object.
+// The result must be returned to invoke_go_func, which raises the lua error.
+func (l *Lua) raisePanic(p interface{}) int {
+	msg := fmt.Sprintf("go panic: %v\n%s", p, debug.Stack())
+	l.goPanic = &goPanic{
+		value:   p,
+		message: msg,
+	}
+	C.lua_checkstack(l.State, 1)
+	l.pushString(msg)
+	return -1
+}
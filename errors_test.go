@@ -0,0 +1,240 @@
+package lgo
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLuaError(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+
+	t.Run("ok", func(t *testing.T) {
+		if err := lua.RunStringE(`local a = 1`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("syntax error", func(t *testing.T) {
+		err := lua.RunStringE(`func end`)
+		var luaErr *LuaError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("got %v", err)
+		}
+		if !errors.Is(err, ErrSyntax) {
+			t.Fatal()
+		}
+		if errors.Is(err, ErrRuntime) {
+			t.Fatal()
+		}
+		if !strings.Contains(luaErr.Message, "syntax error") {
+			t.Fatalf("got %s", luaErr.Message)
+		}
+		if luaErr.Line != 1 {
+			t.Fatalf("got %d", luaErr.Line)
+		}
+	})
+
+	t.Run("runtime error", func(t *testing.T) {
+		err := lua.RunStringE("local a = 1\nerror('foobarbaz')")
+		var luaErr *LuaError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("got %v", err)
+		}
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatal()
+		}
+		if !strings.Contains(luaErr.Message, "foobarbaz") {
+			t.Fatalf("got %s", luaErr.Message)
+		}
+		if !strings.HasPrefix(luaErr.Chunk, "[string") {
+			t.Fatalf("got %s", luaErr.Chunk)
+		}
+		if luaErr.Line != 2 {
+			t.Fatalf("got %d", luaErr.Line)
+		}
+		if !strings.Contains(luaErr.Traceback, "stack traceback") {
+			t.Fatalf("got %s", luaErr.Traceback)
+		}
+	})
+
+	t.Run("non-string error", func(t *testing.T) {
+		err := lua.RunStringE(`error({})`)
+		if err == nil {
+			t.Fatal()
+		}
+		if err.Error() != "(error object is a table value)" {
+			t.Fatalf("got %s", err.Error())
+		}
+	})
+
+	t.Run("call function", func(t *testing.T) {
+		lua.RunString(`
+			function errorE(msg)
+				error(msg)
+			end
+		`)
+		err := lua.CallFunctionE("errorE", "foo")
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(err.Error(), "foo") {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.CallFunctionE("print"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("caught go error", func(t *testing.T) {
+		errFoo := errors.New("foo")
+		lua.RegisterFunction("failFoo", func() error {
+			return errFoo
+		})
+		if err := lua.RunStringE(`pcall(failFoo)`); err != nil {
+			t.Fatal(err)
+		}
+		err := lua.RunStringE(`error('foo', 0)`)
+		if err == nil || err.Error() != "foo" {
+			t.Fatalf("got %v", err)
+		}
+		if errors.Is(err, errFoo) {
+			t.Fatal()
+		}
+		err = lua.RunStringE(`failFoo()`)
+		if !errors.Is(err, errFoo) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("marshal error", func(t *testing.T) {
+		type Secret string
+		errSecret := errors.New("secret")
+		lua.RegisterConverter(reflect.TypeOf(Secret("")), Converter{
+			ToLua: func(value reflect.Value) (interface{}, error) {
+				return nil, errSecret
+			},
+		})
+		err := lua.CallFunctionE("print", Secret("foo"))
+		if !errors.Is(err, ErrTypeMismatch) || !errors.Is(err, errSecret) {
+			t.Fatalf("got %v", err)
+		}
+		err = lua.Call("print", []interface{}{1, Secret("foo")})
+		if !errors.Is(err, ErrTypeMismatch) || !errors.Is(err, errSecret) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.HasPrefix(err.Error(), "arg 2: ") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		lua := New()
+		lua.Close()
+		if err := lua.RunStringE(`local a = 1`); !errors.Is(err, ErrClosed) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.CallFunctionE("print"); !errors.Is(err, ErrClosed) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
+
+func TestDecodeError(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	type Item struct {
+		Name  string  `lua:"name"`
+		Price float64 `lua:"price"`
+	}
+	type Order struct {
+		Items []Item `lua:"items"`
+	}
+	lua.RegisterFunction("shop.setPrice", func(id int, order Order) {})
+	lua.RegisterFunction("setTags", func(tags map[string][]int) {})
+
+	t.Run("path", func(t *testing.T) {
+		err := lua.RunStringE(`
+			local ok, err = pcall(shop.setPrice, 1, {
+				items = {
+					{name = 'a', price = 1},
+					{name = 'b', price = 2},
+					{name = 'c', price = 'free'},
+				},
+			})
+			assert(not ok)
+			error(err, 0)
+		`)
+		if err == nil {
+			t.Fatal()
+		}
+		if err.Error() != "bad argument #2 to 'setPrice' (.items[3].price: float64 expected, got string)" {
+			t.Fatalf("got %v", err)
+		}
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatal()
+		}
+		if decodeErr.Function != "setPrice" ||
+			decodeErr.Arg != 2 ||
+			decodeErr.Path != ".items[3].price" ||
+			decodeErr.Expected != reflect.TypeOf(float64(0)) ||
+			decodeErr.Actual != TypeString {
+			t.Fatalf("got %+v", decodeErr)
+		}
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatal()
+		}
+	})
+
+	t.Run("map keys", func(t *testing.T) {
+		err := lua.RunStringE(`setTags({foo = {1}, ['bar baz'] = {1, 'x'}})`)
+		if err == nil || err.Error() != `bad argument #1 to 'setTags' (["bar baz"][2]: int expected, got string)` {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		err := lua.RunStringE(`shop.setPrice(1, {items = {{name = 'a', cost = 1}}})`)
+		if err == nil || err.Error() != "bad argument #2 to 'setPrice' (.items[1]: no field cost in lgo.Item)" {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("first argument", func(t *testing.T) {
+		err := lua.RunStringE(`shop.setPrice('1', {})`)
+		if err == nil || err.Error() != "bad argument #1 to 'setPrice' (int expected, got string)" {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("results", func(t *testing.T) {
+		lua.RunString(`function getOrder() return {items = {{price = true}}} end`)
+		var order Order
+		err := lua.Call("getOrder", nil, &order)
+		if err == nil || err.Error() != ".items[1].price: float64 expected, got boolean" {
+			t.Fatalf("got %v", err)
+		}
+		// path is reset
+		var i int
+		err = lua.Call("getOrder", nil, &i)
+		if err == nil || err.Error() != "int expected, got table" {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("call context", func(t *testing.T) {
+		lua.RegisterFunction("raw", func(c *CallContext) int {
+			var s string
+			return c.Error("%v", c.Arg(2, &s))
+		})
+		err := lua.RunStringE(`raw(1, {})`)
+		if err == nil || err.Error() != "arg 2: string expected, got table" {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
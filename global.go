@@ -0,0 +1,55 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetGlobal sets the global name, which may be dotted like in RegisterFunction, to v
+func (l *Lua) SetGlobal(name string, v interface{}) {
+	l.checkOpen()
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	name = l.pushNamespace(name)
+	C.lua_checkstack(l.State, 2)
+	C.lua_pushstring(l.State, cstr(name))
+	l.push(v)
+	C.lua_rawset(l.State, -3)
+}
+
+// GetGlobal decodes the value of global name into target.
+// name may be dotted, a missing namespace is decoded as nil.
+func (l *Lua) GetGlobal(name string, target interface{}) error {
+	if l.State == nil {
+		return errClosed
+	}
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	path := strings.Split(name, ".")
+	C.lua_checkstack(l.State, 2)
+	C.lua_getglobal(l.State, cstr(path[0]))
+	for i, key := range path[1:] {
+		switch C.lua_type(l.State, -1) {
+		case C.LUA_TNIL:
+			return l.decode(C.lua_gettop(l.State), target)
+		case C.LUA_TTABLE:
+		default:
+			return &LuaError{
+				Kind:    ErrTypeMismatch,
+				Message: fmt.Sprintf("namespace %s is not a table", strings.Join(path[:i+1], ".")),
+			}
+		}
+		C.lua_pushstring(l.State, cstr(key))
+		C.lua_rawget(l.State, -2)
+		C.lua_copy(l.State, -1, -2)
+		C.lua_settop(l.State, -2)
+	}
+	return l.decode(C.lua_gettop(l.State), target)
+}
@@ -0,0 +1,91 @@
+package lgo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGlobal(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	type Config struct {
+		Name string
+		Port int
+	}
+	lua.SetGlobal("config", Config{
+		Name: "foo",
+		Port: 80,
+	})
+	lua.SetGlobal("app.settings.debug", true)
+	lua.SetGlobal("app.name", `"; os.exit() --`)
+	lua.RunString(`
+		assert(config.Name == 'foo')
+		assert(config.Port == 80)
+		assert(app.settings.debug == true)
+		assert(app.name == '"; os.exit() --')
+		app.version = 42
+		scalar = 1
+	`)
+
+	var config Config
+	if err := lua.GetGlobal("config", &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "foo" || config.Port != 80 {
+		t.Fatal()
+	}
+	var version int
+	if err := lua.GetGlobal("app.version", &version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 42 {
+		t.Fatal()
+	}
+	var debug bool
+	if err := lua.GetGlobal("app.settings.debug", &debug); err != nil {
+		t.Fatal(err)
+	}
+	if !debug {
+		t.Fatal()
+	}
+
+	// missing
+	var v interface{}
+	if err := lua.GetGlobal("foo.bar.baz", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		t.Fatal()
+	}
+
+	// not a table
+	if err := lua.GetGlobal("scalar.foo", &v); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v", err)
+	} else if err.Error() != "namespace scalar is not a table" {
+		t.Fatalf("got %v", err)
+	}
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal()
+			}
+		}()
+		lua.SetGlobal("scalar.foo", 1)
+	}()
+
+	// type mismatch
+	if err := lua.GetGlobal("app.version", &debug); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("got %v", err)
+	}
+
+	// overwrite
+	lua.SetGlobal("app.version", 43)
+	if err := lua.GetGlobal("app.version", &version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 43 {
+		t.Fatal()
+	}
+}
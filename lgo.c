@@ -1,29 +1,416 @@
 #include "lua.h"
+#include "lauxlib.h"
+#include "lualib.h"
+#include "lgo.h"
 #include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
 
-extern int invoke(int64_t);
+extern int invoke(lua_State*, int64_t);
+extern int objectIndex(lua_State*, int64_t);
+extern int objectNewIndex(lua_State*, int64_t);
+extern void objectGC(int64_t);
+extern int hook(lua_State*, int64_t, int);
 
+// memory
+
+static void* alloc_func(void* ud, void* ptr, size_t osize, size_t nsize) {
+  lgo_alloc* a = (lgo_alloc*)ud;
+  if (ptr == NULL) {
+    // osize is the type of the new object
+    osize = 0;
+  }
+  if (nsize == 0) {
+    free(ptr);
+    a->used -= osize;
+    return NULL;
+  }
+  if (a->limited && a->limit != 0 && nsize > osize && a->used - osize + nsize > a->limit) {
+    return NULL;
+  }
+  void* ret = realloc(ptr, nsize);
+  if (ret == NULL) {
+    return NULL;
+  }
+  size_t prev = a->used;
+  a->used = a->used - osize + nsize;
+  if (a->used > a->peak) {
+    a->peak = a->used;
+  }
+  if (a->threshold != 0 && prev < a->threshold && a->used >= a->threshold) {
+    a->threshold_crossed = 1;
+  }
+  return ret;
+}
+
+static int panic_func(lua_State* L) {
+  const char* msg = lua_tostring(L, -1);
+  fprintf(stderr, "PANIC: unprotected error in call to Lua API (%s)\n", msg ? msg : "error object is not a string");
+  return 0;
+}
+
+lua_State* new_state(lgo_alloc* a) {
+  lua_State* L = lua_newstate(alloc_func, a);
+  if (L != NULL) {
+    lua_atpanic(L, panic_func);
+  }
+  return L;
+}
+
+// returns the accounting state of L, or NULL if L uses another allocator
+static lgo_alloc* alloc_of(lua_State* L) {
+  void* ud;
+  if (lua_getallocf(L, &ud) != alloc_func) {
+    return NULL;
+  }
+  return (lgo_alloc*)ud;
+}
+
+// sets whether the memory limit is enforced and returns the previous setting.
+// go code must not run limited, a memory error would unwind go frames.
+static int set_limited(lua_State* L, int limited) {
+  lgo_alloc* a = alloc_of(L);
+  if (a == NULL) {
+    return 0;
+  }
+  int prev = a->limited;
+  a->limited = limited;
+  return prev;
+}
+
+// lua_pcall with the memory limit enforced
+int protected_call(lua_State* L, int nargs, int nresults, int msgh) {
+  int limited = set_limited(L, 1);
+  int ret = lua_pcall(L, nargs, nresults, msgh);
+  set_limited(L, limited);
+  return ret;
+}
+
+// invoke returns -1 after pushing the error object if the call should raise an error
 int invoke_go_func(lua_State* state) {
   int64_t func_id = lua_tointeger(state, lua_upvalueindex(1));
-  return invoke(func_id);
+  int limited = set_limited(state, 0);
+  int ret = invoke(state, func_id);
+  set_limited(state, limited);
+  if (ret < 0) {
+    return lua_error(state);
+  }
+  return ret;
 }
 
-void register_function(lua_State* state, const char* name, int64_t func_id) {
-  lua_pushstring(state, name);
+void push_function(lua_State* state, int64_t func_id) {
   lua_pushinteger(state, func_id);
   lua_pushcclosure(state, (lua_CFunction)invoke_go_func, 1);
+}
+
+void register_function(lua_State* state, const char* name, int64_t func_id) {
+  lua_pushstring(state, name);
+  push_function(state, func_id);
   lua_rawset(state, -3);
 }
 
+// leaves the error object untouched and saves the traceback in the registry
 int traceback(lua_State* L) {
-  lua_getglobal(L, "debug");
-  lua_getfield(L, -1, "traceback");
-  lua_pushvalue(L, 1);
-  lua_pushinteger(L, 2);
-  lua_call(L, 2, 1);
+  // level 1 is the function raising the error, works without the debug library
+  luaL_traceback(L, L, NULL, 1);
+  lua_setfield(L, LUA_REGISTRYINDEX, "lgo.traceback");
+  lua_settop(L, 1);
   return 1;
 }
 
 void setup_message_handler(lua_State* L) {
   lua_pushcfunction(L, traceback);
 }
+
+// objects
+
+int object_index(lua_State* L) {
+  int limited = set_limited(L, 0);
+  int ret = objectIndex(L, *(int64_t*)lua_touserdata(L, 1));
+  set_limited(L, limited);
+  if (ret < 0) {
+    return lua_error(L);
+  }
+  return ret;
+}
+
+int object_newindex(lua_State* L) {
+  int limited = set_limited(L, 0);
+  int ret = objectNewIndex(L, *(int64_t*)lua_touserdata(L, 1));
+  set_limited(L, limited);
+  if (ret < 0) {
+    return lua_error(L);
+  }
+  return ret;
+}
+
+int object_gc(lua_State* L) {
+  int64_t* handle = (int64_t*)lua_touserdata(L, 1);
+  if (*handle != 0) {
+    int limited = set_limited(L, 0);
+    objectGC(*handle);
+    set_limited(L, limited);
+    *handle = 0;
+  }
+  return 0;
+}
+
+// sets up the object metamethods of the table at the top
+void setup_object_metatable(lua_State* L, const char* name) {
+  lua_pushboolean(L, 1);
+  lua_setfield(L, -2, "lgo.object");
+  lua_pushstring(L, name);
+  lua_setfield(L, -2, "__metatable");
+  lua_pushstring(L, name);
+  lua_setfield(L, -2, "__name");
+  lua_pushcfunction(L, object_index);
+  lua_setfield(L, -2, "__index");
+  lua_pushcfunction(L, object_newindex);
+  lua_setfield(L, -2, "__newindex");
+  lua_pushcfunction(L, object_gc);
+  lua_setfield(L, -2, "__gc");
+}
+
+void push_object(lua_State* L, int64_t handle, int metatable) {
+  int64_t* p = (int64_t*)lua_newuserdata(L, sizeof(int64_t));
+  *p = handle;
+  lua_rawgeti(L, LUA_REGISTRYINDEX, metatable);
+  lua_setmetatable(L, -2);
+}
+
+// returns the handle of the object at idx, or 0 if the value is not an object
+int64_t to_object(lua_State* L, int idx) {
+  int64_t handle = 0;
+  if (lua_type(L, idx) != LUA_TUSERDATA || !lua_getmetatable(L, idx)) {
+    return 0;
+  }
+  lua_pushstring(L, "lgo.object");
+  lua_rawget(L, -2);
+  if (lua_toboolean(L, -1)) {
+    handle = *(int64_t*)lua_touserdata(L, idx);
+  }
+  lua_pop(L, 2);
+  return handle;
+}
+
+// calls the function in the first upvalue with all arguments except the first
+int call_without_first(lua_State* L) {
+  lua_pushvalue(L, lua_upvalueindex(1));
+  lua_replace(L, 1);
+  lua_call(L, lua_gettop(L) - 1, LUA_MULTRET);
+  return lua_gettop(L);
+}
+
+// calls the function in the first upvalue with the first argument only
+int call_with_first(lua_State* L) {
+  lua_settop(L, 1);
+  lua_pushvalue(L, lua_upvalueindex(1));
+  lua_insert(L, 1);
+  lua_call(L, 1, 1);
+  return 1;
+}
+
+// sets a metatable calling the function at the top to the table below it, the function is popped
+void set_call_metatable(lua_State* L) {
+  lua_createtable(L, 0, 1);
+  lua_insert(L, -2);
+  lua_pushcclosure(L, call_without_first, 1);
+  lua_setfield(L, -2, "__call");
+  lua_setmetatable(L, -2);
+}
+
+// sets the method function to the metamethod name of the table at the top
+void set_metamethod(lua_State* L, const char* name, int64_t func_id, int first_only) {
+  push_function(L, func_id);
+  if (first_only) {
+    lua_pushcclosure(L, call_with_first, 1);
+  }
+  lua_setfield(L, -2, name);
+}
+
+// table operations that may raise errors, to be called in protected mode
+
+static int table_get(lua_State* L) {
+  lua_gettable(L, 1);
+  return 1;
+}
+
+static int table_set(lua_State* L) {
+  lua_settable(L, 1);
+  return 0;
+}
+
+static int table_rawset(lua_State* L) {
+  lua_rawset(L, 1);
+  return 0;
+}
+
+static int table_len(lua_State* L) {
+  lua_len(L, 1);
+  return 1;
+}
+
+static const lua_CFunction table_ops[] = {
+  table_get,
+  table_set,
+  table_rawset,
+  table_len,
+};
+
+void push_table_op(lua_State* L, int op) {
+  lua_pushcfunction(L, table_ops[op]);
+}
+
+// hooks
+
+// hook_func calls the go hook with the handle of the Lua saved in the registry
+static void hook_func(lua_State* L, lua_Debug* ar) {
+  lua_getfield(L, LUA_REGISTRYINDEX, "lgo.lua");
+  int64_t handle = lua_tointeger(L, -1);
+  lua_pop(L, 1);
+  int limited = set_limited(L, 0);
+  int ret = hook(L, handle, ar->event);
+  set_limited(L, limited);
+  if (ret < 0) {
+    lua_error(L);
+  }
+}
+
+// set_hook installs hook_func, or removes it if mask is 0
+void set_hook(lua_State* L, int mask, int count) {
+  lua_sethook(L, mask ? hook_func : NULL, mask, count);
+}
+
+// sandbox
+
+static const struct {
+  const char* name;
+  const char* modname;
+  lua_CFunction open;
+} sandbox_libs[] = {
+  {"base", "_G", luaopen_base},
+  {"string", LUA_STRLIBNAME, luaopen_string},
+  {"table", LUA_TABLIBNAME, luaopen_table},
+  {"math", LUA_MATHLIBNAME, luaopen_math},
+  {"utf8", LUA_UTF8LIBNAME, luaopen_utf8},
+  {"coroutine", LUA_COLIBNAME, luaopen_coroutine},
+  {NULL, NULL, NULL},
+};
+
+// opens the library of the sandbox named name, returns 0 if there is none
+int open_sandbox_lib(lua_State* L, const char* name) {
+  for (int i = 0; sandbox_libs[i].name != NULL; i++) {
+    if (strcmp(sandbox_libs[i].name, name) == 0) {
+      luaL_requiref(L, sandbox_libs[i].modname, sandbox_libs[i].open, 1);
+      lua_pop(L, 1);
+      return 1;
+    }
+  }
+  return 0;
+}
+
+// calls the function in the first upvalue with all arguments
+static int call_upvalue(lua_State* L) {
+  lua_pushvalue(L, lua_upvalueindex(1));
+  lua_insert(L, 1);
+  lua_call(L, lua_gettop(L) - 1, LUA_MULTRET);
+  return lua_gettop(L);
+}
+
+// load with the mode forced to text
+static int load_text(lua_State* L) {
+  if (lua_gettop(L) < 3) {
+    // keeps the env argument absent
+    lua_settop(L, 3);
+  }
+  lua_pushliteral(L, "t");
+  lua_replace(L, 3);
+  return call_upvalue(L);
+}
+
+// collectgarbage without the options tuning or stopping the collector
+static int collectgarbage_safe(lua_State* L) {
+  static const char* const options[] = {"collect", "count", "step", "isrunning", NULL};
+  luaL_checkoption(L, 1, "collect", options);
+  return call_upvalue(L);
+}
+
+// setmetatable rejecting metatables with __gc.
+// finalizers may run in any allocation or in lua_close, outside calls where no hook checks limits.
+static int setmetatable_safe(lua_State* L) {
+  if (lua_type(L, 2) == LUA_TTABLE) {
+    lua_pushliteral(L, "__gc");
+    if (lua_rawget(L, 2) != LUA_TNIL) {
+      return luaL_error(L, "__gc metamethods are not allowed in sandbox");
+    }
+    lua_pop(L, 1);
+  }
+  return call_upvalue(L);
+}
+
+// print passing the line to the function in the first upvalue
+static int print_func(lua_State* L) {
+  int n = lua_gettop(L);
+  luaL_Buffer b;
+  luaL_buffinit(L, &b);
+  for (int i = 1; i <= n; i++) {
+    if (i > 1) {
+      luaL_addchar(&b, '\t');
+    }
+    luaL_tolstring(L, i, NULL);
+    luaL_addvalue(&b);
+  }
+  luaL_pushresult(&b);
+  lua_pushvalue(L, lua_upvalueindex(1));
+  lua_insert(L, -2);
+  lua_call(L, 1, 0);
+  return 0;
+}
+
+// replaces the global name with f, which gets the original function as its upvalue
+static void wrap_global(lua_State* L, const char* name, lua_CFunction f) {
+  lua_getglobal(L, name);
+  if (lua_isnil(L, -1)) {
+    lua_pop(L, 1);
+    return;
+  }
+  lua_pushcclosure(L, f, 1);
+  lua_setglobal(L, name);
+}
+
+// load defaulting the env argument to the env in the second upvalue
+static int load_env(lua_State* L) {
+  if (lua_gettop(L) < 4) {
+    lua_settop(L, 4);
+    lua_pushvalue(L, lua_upvalueindex(2));
+    lua_replace(L, 4);
+  }
+  return call_upvalue(L);
+}
+
+// sets load of the env on top of the stack to load chunks in the env
+void setup_env(lua_State* L) {
+  lua_getglobal(L, "load");
+  if (lua_isnil(L, -1)) {
+    lua_pop(L, 1);
+    return;
+  }
+  lua_pushvalue(L, -2);
+  lua_pushcclosure(L, load_env, 2);
+  lua_setfield(L, -2, "load");
+}
+
+// removes or restricts the unsafe base functions and sets print to call the go function print_id with the line
+void setup_sandbox(lua_State* L, int64_t print_id) {
+  lua_pushnil(L);
+  lua_setglobal(L, "dofile");
+  lua_pushnil(L);
+  lua_setglobal(L, "loadfile");
+  wrap_global(L, "load", load_text);
+  wrap_global(L, "collectgarbage", collectgarbage_safe);
+  wrap_global(L, "setmetatable", setmetatable_safe);
+  push_function(L, print_id);
+  lua_pushcclosure(L, print_func, 1);
+  lua_setglobal(L, "print");
+}
@@ -6,10 +6,13 @@ package lgo
 #include <lauxlib.h>
 #include <string.h>
 #include <stdint.h>
+#include <stdlib.h>
+#include "lgo.h"
 
 void register_function(lua_State*, const char*, int64_t);
 void setup_message_handler(lua_State*);
 int traceback(lua_State*);
+int protected_call(lua_State*, int, int, int);
 
 #cgo !windows LDFLAGS: -lm -llua
 
@@ -17,17 +20,22 @@ int traceback(lua_State*);
 import "C"
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"runtime/cgo"
 	"strings"
 	"sync"
+	"unsafe"
 
 	"github.com/reusee/sb"
 )
 
 var (
 	cstrs sync.Map
+
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	boolType  = reflect.TypeOf(false)
 )
 
 func cstr(str string) *C.char {
@@ -41,9 +49,55 @@ func cstr(str string) *C.char {
 }
 
 type Lua struct {
+	// State is the main thread, or the running thread while a go function is called from lua
 	State          *C.lua_State
 	PrintTraceback bool
 	NonStrict      bool
+	// TableMode is the policy of decoding tables into interface{} targets
+	TableMode TableMode
+	// JSONTags makes struct fields without lua tags named by json tags
+	JSONTags bool
+	// CaseInsensitiveFields makes table keys match struct field names case insensitively
+	CaseInsensitiveFields bool
+	// TimeFormat is the layout of time.Time values pushed as strings, they are pushed as Unix seconds if empty
+	TimeFormat string
+	// Limits are the quotas enforced on calls into lua
+	Limits Limits
+
+	handles map[cgo.Handle]struct{}
+	// handle of the Lua itself, saved in the registry for the hook
+	handle     cgo.Handle
+	metatables map[reflect.Type]C.int
+	methods    map[methodKey]cgo.Handle
+	types      map[reflect.Type]*class
+	converters map[reflect.Type]*Converter
+
+	// the go error last raised as lua error and its message
+	raisedError   error
+	raisedMessage string
+	// the go panic recovered in a registered function
+	goPanic *goPanic
+	// keys of nested tables being decoded
+	decodePath []string
+	// context of the running RunStringContext or CallContext
+	ctx context.Context
+	// depth of nested pcalls, the outermost one is a call
+	calls int
+	usage Usage
+	// number of instructions the hook is installed to run after
+	hookInstructions int64
+	// accounting state of the allocator, nil if created by New
+	alloc *C.lgo_alloc
+	// called when allocated memory crosses Options.MemoryThreshold
+	onMemoryThreshold func(used int64)
+	// created by NewSandbox, scripts are only loaded as text
+	sandbox bool
+
+	// registry references to release, appended by finalizers
+	releaseLock sync.Mutex
+	released    []C.int
+	// callbacks by funcKey, guarded by releaseLock
+	callbacks map[uintptr]*callback
 }
 
 type _Function struct {
@@ -52,7 +106,23 @@ type _Function struct {
 	fun       interface{}
 	funcType  reflect.Type
 	funcValue reflect.Value
-	argc      int
+	// number of parameters taking lua arguments
+	argc int
+	// first parameter is a context.Context, passed the context of the running call
+	withContext bool
+	// last return value is an error, raised as lua error if not nil
+	returnsError bool
+	optionalArgs bool
+	// function registered as func(*CallContext) int
+	raw func(*CallContext) int
+}
+
+// FunctionOption configures a registered function
+type FunctionOption func(*_Function)
+
+// OptionalArgs allows calling the function with fewer arguments, missing arguments will be zero values
+var OptionalArgs FunctionOption = func(f *_Function) {
+	f.optionalArgs = true
 }
 
 func New() *Lua {
@@ -61,16 +131,84 @@ func New() *Lua {
 		panic("lua state create error")
 	}
 	C.luaL_openlibs(state)
+	return newLua(state)
+}
+
+// newLua sets up a Lua on the opened state
+func newLua(state *C.lua_State) *Lua {
 	lua := &Lua{
 		State:          state,
 		PrintTraceback: true,
+		handles:        make(map[cgo.Handle]struct{}),
+		metatables:     make(map[reflect.Type]C.int),
+		methods:        make(map[methodKey]cgo.Handle),
+		types:          make(map[reflect.Type]*class),
+		converters:     make(map[reflect.Type]*Converter),
+		callbacks:      make(map[uintptr]*callback),
 	}
+	lua.registerBuiltinConverters()
+	// not in handles, registered functions are counted there
+	lua.handle = cgo.NewHandle(lua)
+	C.lua_pushinteger(state, C.lua_Integer(lua.handle))
+	C.lua_setfield(state, C.LUA_REGISTRYINDEX, cstr(luaHandleKey))
 	return lua
 }
 
 var NewLua = New
 
-func (l *Lua) RegisterFunction(name string, fun interface{}) {
+// Close closes the lua state and releases all handles created for it.
+// Further calls on a closed Lua panic. Calling Close more than once is a no-op.
+func (l *Lua) Close() {
+	if l.State == nil {
+		return
+	}
+	C.lua_close(l.State)
+	l.State = nil
+	if l.alloc != nil {
+		C.free(unsafe.Pointer(l.alloc))
+		l.alloc = nil
+	}
+	for handle := range l.handles {
+		handle.Delete()
+	}
+	l.handles = nil
+	l.handle.Delete()
+}
+
+// enter makes state, the thread calling a go function from lua, the State of l until the returned function is called.
+// Inside a coroutine it is not the main thread.
+func (l *Lua) enter(state *C.lua_State) (leave func()) {
+	main := l.State
+	l.State = state
+	return func() {
+		l.State = main
+	}
+}
+
+func (l *Lua) checkOpen() {
+	if l.State == nil {
+		l.Panic("lua state closed")
+	}
+}
+
+func (l *Lua) newHandle(v interface{}) cgo.Handle {
+	handle := cgo.NewHandle(v)
+	l.handles[handle] = struct{}{}
+	return handle
+}
+
+func (l *Lua) RegisterFunction(name string, fun interface{}, options ...FunctionOption) {
+	l.checkOpen()
+	name = l.pushNamespace(name)
+	function := l.newFunction(name, fun, options...)
+	handle := l.newHandle(function)
+	C.register_function(l.State, cstr(name), (C.int64_t)(handle))
+	C.lua_settop(l.State, -2)
+}
+
+// pushNamespace pushes the namespace table of a dotted name, creating missing tables.
+// It returns the last component of the name.
+func (l *Lua) pushNamespace(name string) string {
 	path := strings.Split(name, ".")
 	name = path[len(path)-1]
 	path = path[0 : len(path)-1]
@@ -90,6 +228,7 @@ func (l *Lua) RegisterFunction(name string, fun interface{}) {
 				C.lua_getglobal(l.State, cNamespace)
 			}
 			if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+				C.lua_settop(l.State, -2)
 				l.Panic("global %s is not a table", namespace)
 			}
 		} else { // sub namespace
@@ -103,30 +242,42 @@ func (l *Lua) RegisterFunction(name string, fun interface{}) {
 				C.lua_pushstring(l.State, cNamespace)
 				C.lua_rawget(l.State, -2)
 			}
+			// replace the parent
+			C.lua_copy(l.State, -1, -2)
+			C.lua_settop(l.State, -2)
 			if C.lua_type(l.State, -1) != C.LUA_TTABLE {
+				C.lua_settop(l.State, -2)
 				l.Panic("namespace %s is not a table", namespace)
 			}
 		}
 	}
 
-	// register function
+	return name
+}
+
+func (l *Lua) newFunction(name string, fun interface{}, options ...FunctionOption) *_Function {
 	funcType := reflect.TypeOf(fun)
-	if funcType.IsVariadic() {
-		l.Panic("cannot register variadic function: %v", fun)
-	}
-	argc := funcType.NumIn()
-	cName := cstr(name)
+	numOut := funcType.NumOut()
 	function := &_Function{
-		fun:       fun,
-		lua:       l,
-		name:      name,
-		funcType:  funcType,
-		funcValue: reflect.ValueOf(fun),
-		argc:      argc,
-	}
-	handle := cgo.NewHandle(function)
-	C.register_function(l.State, cName, (C.int64_t)(handle))
-	C.lua_settop(l.State, -2)
+		fun:          fun,
+		lua:          l,
+		name:         name,
+		funcType:     funcType,
+		funcValue:    reflect.ValueOf(fun),
+		argc:         funcType.NumIn(),
+		returnsError: numOut > 0 && funcType.Out(numOut-1) == errorType,
+	}
+	if raw, ok := fun.(func(*CallContext) int); ok {
+		function.raw = raw
+	}
+	if funcType.NumIn() > 0 && funcType.In(0) == contextType {
+		function.withContext = true
+		function.argc--
+	}
+	for _, option := range options {
+		option(function)
+	}
+	return function
 }
 
 func (l *Lua) RegisterFunctions(funcs map[string]interface{}) {
@@ -136,88 +287,283 @@ func (l *Lua) RegisterFunctions(funcs map[string]interface{}) {
 }
 
 //export invoke
-func invoke(_handle uint64) int {
+func invoke(state *C.lua_State, _handle uint64) (ret int) {
 	handle := cgo.Handle(_handle)
 	function := handle.Value().(*_Function)
+	defer function.lua.enter(state)()
+	// a go panic must not unwind through lua frames
+	defer func() {
+		if p := recover(); p != nil {
+			ret = function.lua.raiseRecovered(p)
+		}
+	}()
+	if n := function.lua.countGoCall(); n < 0 {
+		return n
+	}
+	if function.raw != nil {
+		return function.raw(&CallContext{
+			lua:   function.lua,
+			state: state,
+			argc:  int(C.lua_gettop(state)),
+		})
+	}
+	offset := 0
+	if function.withContext {
+		offset = 1
+	}
 	// check argument count
-	argc := C.lua_gettop(function.lua.State)
-	if int(argc) != function.argc {
-		function.lua.Panic("arguments not match: %v", function.fun)
+	argc := int(C.lua_gettop(function.lua.State))
+	numFixed := function.argc
+	if function.funcType.IsVariadic() {
+		numFixed--
+	}
+	if argc < numFixed && !function.optionalArgs ||
+		argc > numFixed && !function.funcType.IsVariadic() {
+		return function.lua.raiseError(function.countError(argc, numFixed, offset))
 	}
 	// arguments
-	var args []reflect.Value
-	for i := C.int(1); i <= argc; i++ {
-		t := function.funcType.In(int(i - 1))
+	args := make([]reflect.Value, 0, argc+offset)
+	if function.withContext {
+		args = append(args, reflect.ValueOf(function.lua.context()))
+	}
+	for i := 0; i < argc; i++ {
+		var t reflect.Type
+		if i < numFixed {
+			t = function.funcType.In(i + offset)
+		} else {
+			t = function.funcType.In(numFixed + offset).Elem()
+		}
 		arg := reflect.New(t)
-		proc := decodeStack(function.lua, C.int(i), t, nil)
-		ce(sb.Copy(
-			&proc,
-			sb.Unmarshal(arg.Interface()),
-		))
+		if err := function.lua.decode(C.int(i+1), arg.Interface()); err != nil {
+			panic(argError(err, function.name, i+1))
+		}
 		args = append(args, arg.Elem())
 	}
+	for i := argc; i < numFixed; i++ {
+		args = append(args, reflect.New(function.funcType.In(i+offset)).Elem())
+	}
 	// call and returns
 	returnValues := function.funcValue.Call(args)
 	if len(returnValues) != function.funcType.NumOut() { //NOCOVER
 		function.lua.Panic("return values not match: %v", function.fun)
 	}
+	if function.returnsError {
+		errValue := returnValues[len(returnValues)-1]
+		returnValues = returnValues[:len(returnValues)-1]
+		if !errValue.IsNil() {
+			return function.lua.raiseError(errValue.Interface().(error))
+		}
+	}
 	for _, v := range returnValues {
-		proc := sb.MarshalValue(sb.DefaultCtx, v, nil)
 		ce(sb.Copy(
-			&proc,
+			function.lua.marshal(v),
 			pushValue(function.lua, nil),
 		))
 	}
 	return len(returnValues)
 }
 
+// countError returns the error of calling the function with argc arguments
+func (f *_Function) countError(argc, numFixed, offset int) error {
+	e := &DecodeError{
+		Function: f.name,
+	}
+	if argc < numFixed {
+		e.Arg = argc + 1
+		e.Expected = f.funcType.In(argc + offset)
+		e.Actual = TypeNone
+	} else {
+		e.Arg = numFixed + 1
+		e.Actual = LuaType(C.lua_type(f.lua.State, C.int(e.Arg)))
+		e.Reason = fmt.Sprintf("no value expected, got %v", e.Actual)
+	}
+	return &LuaError{
+		Kind:    ErrCountMismatch,
+		Message: e.Error(),
+		Err:     e,
+	}
+}
+
 func (l *Lua) RunString(code string) {
-	defer func() {
-		if r := recover(); r != nil {
-			if l.PrintTraceback { //NOCOVER
-				print("============ start lua traceback ============\n")
-				l.RunString(`print(debug.traceback())`)
-				print("============ end lua traceback ==============\n")
-			}
-			panic(r)
-		}
-	}()
-	cCode := cstr(code)
+	l.checkOpen()
+	if err := l.RunStringE(code); err != nil {
+		l.panicError(err)
+	}
+}
+
+// RunStringE runs code and returns a *LuaError on failure instead of panicking
+func (l *Lua) RunStringE(code string) error {
+	return l.runString(code, nil)
+}
+
+// runString runs code with env as its _ENV, or the global table if env is nil
+func (l *Lua) runString(code string, env *Env) error {
+	if l.State == nil {
+		return errClosed
+	}
+	l.release()
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
 	C.setup_message_handler(l.State)
-	if ret := C.luaL_loadstring(l.State, cCode); ret != C.int(0) {
-		l.Panic("%s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
+	// not cached by cstr, code may contain NUL bytes and scripts are not reused
+	cCode := C.CString(code)
+	var mode *C.char
+	if l.sandbox {
+		// lua does not verify binary chunks
+		mode = cstr("t")
 	}
-	ret := C.lua_pcallk(l.State, 0, 0, C.lua_gettop(l.State)-C.int(1), 0, nil)
+	ret := C.luaL_loadbufferx(l.State, cCode, C.size_t(len(code)), cCode, mode)
+	C.free(unsafe.Pointer(cCode))
 	if ret != C.int(0) {
-		l.Panic("%s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
+		return l.newError(ret)
+	}
+	if env != nil {
+		env.Push()
+		// the only upvalue of a main chunk is _ENV
+		C.lua_setupvalue(l.State, -2, 1)
 	}
-	C.lua_settop(l.State, 0)
+	ret = l.pcall(0, 0, top+1)
+	if ret != C.int(0) {
+		return l.newError(ret)
+	}
+	return nil
 }
 
 func (l *Lua) CallFunction(name string, args ...interface{}) {
-	defer func() {
-		if r := recover(); r != nil {
-			if l.PrintTraceback { //NOCOVER
-				print("============ start lua traceback ============\n")
-				l.RunString(`print(debug.traceback())`)
-				print("============ end lua traceback ==============\n")
-			}
-			panic(r)
-		}
-	}()
+	l.checkOpen()
+	if err := l.CallFunctionE(name, args...); err != nil {
+		l.panicError(err)
+	}
+}
+
+// CallFunctionE calls the global function name and returns a *LuaError on failure instead of panicking
+func (l *Lua) CallFunctionE(name string, args ...interface{}) error {
+	if l.State == nil {
+		return errClosed
+	}
+	l.release()
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
 	cName := cstr(name)
 	C.setup_message_handler(l.State)
 	C.lua_getglobal(l.State, cName)
-	for _, arg := range args {
-		ce(sb.Copy(
-			sb.Marshal(arg),
-			pushValue(l, nil),
-		))
+	for i, arg := range args {
+		if err := l.pushArg(i+1, reflect.ValueOf(arg)); err != nil {
+			return err
+		}
 	}
-	ret := C.lua_pcallk(l.State, C.int(len(args)), 0, C.lua_gettop(l.State)-C.int(len(args)+2), 0, nil)
+	ret := l.pcall(C.int(len(args)), 0, top+1)
 	if ret != C.int(0) {
-		l.Panic("%s", C.GoString(C.lua_tolstring(l.State, -1, nil)))
+		return l.newError(ret)
+	}
+	return nil
+}
+
+// Call calls the global function name with args and decodes the return values into rets.
+// Every element of rets must be a non-nil pointer, and the function must return exactly len(rets) values.
+func (l *Lua) Call(name string, args []interface{}, rets ...interface{}) error {
+	return l.call(func() {
+		C.lua_getglobal(l.State, cstr(name))
+	}, args, rets)
+}
+
+// call calls the function pushed by pushFunc
+func (l *Lua) call(pushFunc func(), args []interface{}, rets []interface{}) error {
+	if l.State == nil {
+		return errClosed
+	}
+	l.release()
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, C.int(len(args)+2))
+	C.setup_message_handler(l.State)
+	pushFunc()
+	for i, arg := range args {
+		if err := l.pushArg(i+1, reflect.ValueOf(arg)); err != nil {
+			return err
+		}
+	}
+	ret := l.pcall(C.int(len(args)), C.LUA_MULTRET, top+1)
+	if ret != C.int(0) {
+		return l.newError(ret)
+	}
+	return l.decodeResults(top+1, rets)
+}
+
+// pushArg pushes the i-th argument of a call, a marshal error is returned as a *LuaError of ErrTypeMismatch
+func (l *Lua) pushArg(i int, arg reflect.Value) error {
+	if err := sb.Copy(
+		l.marshal(arg),
+		pushValue(l, nil),
+	); err != nil {
+		return &LuaError{
+			Kind:    ErrTypeMismatch,
+			Message: fmt.Sprintf("arg %d: %v", i, err),
+			Err:     err,
+		}
+	}
+	return nil
+}
+
+// decodeResults decodes the values above base into rets
+func (l *Lua) decodeResults(base C.int, rets []interface{}) error {
+	n := int(C.lua_gettop(l.State) - base)
+	if n != len(rets) {
+		return &LuaError{
+			Kind:    ErrCountMismatch,
+			Message: fmt.Sprintf("expecting %d return values, got %d", len(rets), n),
+		}
+	}
+	for i, target := range rets {
+		ptr := reflect.ValueOf(target)
+		if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+			return &LuaError{
+				Kind:    ErrTypeMismatch,
+				Message: fmt.Sprintf("bad return value target #%d: %T", i+1, target),
+			}
+		}
+		value := reflect.New(ptr.Type().Elem())
+		if err := l.decode(base+C.int(i+1), value.Interface()); err != nil {
+			return err
+		}
+		ptr.Elem().Set(value.Elem())
+	}
+	return nil
+}
+
+// pcall calls lua_pcallk and re-panics the go panic that caused the call to fail, if any.
+// The outermost pcall is a call accounted in Usage and checked against Limits.
+func (l *Lua) pcall(nargs, nresults, msgh C.int) C.int {
+	if l.calls == 0 {
+		l.beginCall()
+	}
+	l.calls++
+	ret := C.protected_call(l.State, nargs, nresults, msgh)
+	l.calls--
+	if l.calls == 0 {
+		l.endCall()
+		if ret == C.int(0) {
+			// raised by go functions and caught by the script
+			l.clearRaised()
+		}
+	}
+	p := l.goPanic
+	l.goPanic = nil
+	if ret != C.int(0) && p != nil &&
+		C.lua_type(l.State, -1) == C.LUA_TSTRING &&
+		l.toString(-1) == p.message {
+		panic(p.value)
+	}
+	return ret
+}
+
+func (l *Lua) panicError(err error) {
+	if e, ok := err.(*LuaError); ok && l.PrintTraceback && e.Traceback != "" { //NOCOVER
+		print("============ start lua traceback ============\n")
+		print(e.Traceback, "\n")
+		print("============ end lua traceback ==============\n")
 	}
+	l.Panic("%s", err)
 }
 
 func (l *Lua) Panic(format string, args ...interface{}) {
@@ -0,0 +1,15 @@
+#include <stddef.h>
+
+// accounting state of the allocator of states created by NewWithOptions
+typedef struct {
+  size_t used;
+  size_t peak;
+  // hard limit, 0 for none
+  size_t limit;
+  // soft threshold, 0 for none
+  size_t threshold;
+  // the limit is enforced while lua code runs, not while go code does
+  int limited;
+  // set when used crosses threshold upward, cleared by go
+  int threshold_crossed;
+} lgo_alloc;
@@ -2,7 +2,10 @@ package lgo
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
 	"unsafe"
@@ -136,7 +139,7 @@ func TestAll(t *testing.T) {
 
 	t.Run("interface argument", func(t *testing.T) {
 		lua.RegisterFunction("interface", func(a, b interface{}) {
-			if i, ok := a.(float64); !ok || i != 42 {
+			if i, ok := a.(int64); !ok || i != 42 {
 				t.Fatalf("got %v", a)
 			}
 			if s, ok := b.(string); !ok || s != "foo" {
@@ -283,16 +286,46 @@ func TestAll(t *testing.T) {
 	})
 
 	t.Run("register variadic function", func(t *testing.T) {
-		defer func() {
-			p := recover()
-			if p == nil {
-				t.Fatal()
-			}
-			if !strings.HasPrefix(p.(string), "cannot register variadic function") {
-				t.Fatal()
+		lua.RegisterFunction("variadic", func(format string, args ...interface{}) string {
+			return fmt.Sprintf(format, args...)
+		})
+		lua.RunString(`
+			if variadic('foo') ~= 'foo' then error('no args') end
+			if variadic('%s %v', 'foo', true) ~= 'foo true' then error('args') end
+		`)
+		lua.RegisterFunction("sum", func(is ...int) (ret int) {
+			for _, i := range is {
+				ret += i
 			}
+			return
+		})
+		lua.RunString(`
+			if sum() ~= 0 then error('no args') end
+			if sum(1, 2, 3) ~= 6 then error('args') end
+		`)
+	})
+
+	t.Run("optional arguments", func(t *testing.T) {
+		lua.RegisterFunction("optional", func(s string, i int, b bool) string {
+			return fmt.Sprintf("%s %d %v", s, i, b)
+		}, OptionalArgs)
+		lua.RunString(`
+			if optional() ~= ' 0 false' then error('no args') end
+			if optional('foo') ~= 'foo 0 false' then error('one arg') end
+			if optional('foo', 1, true) ~= 'foo 1 true' then error('all args') end
+		`)
+		func() {
+			defer func() {
+				p := recover()
+				if p == nil {
+					t.Fatal()
+				}
+				if p.(string) != "bad argument #4 to 'optional' (no value expected, got number)" {
+					t.Fatalf("got %v", p)
+				}
+			}()
+			lua.RunString(`optional('foo', 1, true, 1)`)
 		}()
-		lua.RegisterFunction("foo", func(is ...int) {})
 	})
 
 	t.Run("panic", func(t *testing.T) {
@@ -385,8 +418,8 @@ func TestAll(t *testing.T) {
 			if p == nil {
 				t.Fatal()
 			}
-			if !strings.HasPrefix(p.(string), "arguments not match") {
-				t.Fatal()
+			if p.(string) != "bad argument #2 to 'foo' (int expected, got no value)" {
+				t.Fatalf("got %v", p)
 			}
 		}()
 		lua.CallFunction("foo", 1)
@@ -404,7 +437,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting bool" {
+			if msg != "bad argument #1 to 'foo' (bool expected, got table)" {
 				t.Fatalf("got %s", msg)
 			}
 		}()
@@ -424,7 +457,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting int" {
+			if msg != "bad argument #1 to 'foo' (int expected, got table)" {
 				t.Fatalf("got %s", msg)
 			}
 		}()
@@ -444,7 +477,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting uint" {
+			if msg != "bad argument #1 to 'foo' (uint expected, got table)" {
 				t.Fatal()
 			}
 		}()
@@ -464,7 +497,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting float64" {
+			if msg != "bad argument #1 to 'foo' (float64 expected, got table)" {
 				t.Fatal()
 			}
 		}()
@@ -494,11 +527,11 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "function type not supported" {
+			if msg != "bad argument #1 to 'foo' (interface {} expected, got thread)" {
 				t.Fatalf("got %s", msg)
 			}
 		}()
-		lua.RunString(`foo(function() end)`)
+		lua.RunString(`foo(coroutine.create(print))`)
 	})
 
 	t.Run("invalid string", func(t *testing.T) {
@@ -509,7 +542,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting string" {
+			if msg != "bad argument #1 to 'invalidstring' (string expected, got number)" {
 				t.Fatal()
 			}
 		}()
@@ -524,7 +557,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting []int" {
+			if msg != "bad argument #1 to 'invalidslice' ([]int expected, got number)" {
 				t.Fatal()
 			}
 		}()
@@ -551,7 +584,7 @@ func TestAll(t *testing.T) {
 				t.Fatal()
 			}
 			msg := fmt.Sprintf("%s", p)
-			if msg != "type mismatch, expecting map[int]bool" {
+			if msg != "bad argument #1 to 'invalidmap' (map[int]bool expected, got number)" {
 				t.Fatal()
 			}
 		}()
@@ -623,3 +656,807 @@ func TestAll(t *testing.T) {
 		lua.CallFunction("foobarbaz")
 	})
 }
+
+func TestClose(t *testing.T) {
+	lua := New()
+	lua.PrintTraceback = false
+	lua.RegisterFunction("foo", func() {})
+	lua.RegisterFunction("bar.baz", func(i int) {})
+	if len(lua.handles) != 2 {
+		t.Fatal()
+	}
+	lua.Close()
+	if lua.State != nil {
+		t.Fatal()
+	}
+	if len(lua.handles) != 0 {
+		t.Fatal()
+	}
+	lua.Close() // no-op
+
+	func() {
+		defer func() {
+			p := recover()
+			if p == nil {
+				t.Fatal()
+			}
+			if p.(string) != "lua state closed" {
+				t.Fatalf("got %v", p)
+			}
+		}()
+		lua.RunString(`foo()`)
+	}()
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal()
+			}
+		}()
+		lua.CallFunction("foo")
+	}()
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal()
+			}
+		}()
+		lua.RegisterFunction("foo", func() {})
+	}()
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil || p.(string) != "lua state closed" {
+				t.Fatalf("got %v", p)
+			}
+		}()
+		lua.PushObject(nil)
+	}()
+}
+
+func TestCall(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.RunString(`
+		function add(a, b)
+			return a + b
+		end
+		function multi()
+			return 42, 'foo', {1, 2, 3}, true
+		end
+		function nothing()
+		end
+		function object()
+			return {I = 42, S = 'foo'}
+		end
+		function fail()
+			error('foo')
+		end
+	`)
+
+	t.Run("single", func(t *testing.T) {
+		var i int
+		if err := lua.Call("add", []interface{}{40, 2}, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != 42 {
+			t.Fatalf("got %d", i)
+		}
+	})
+
+	t.Run("multiple", func(t *testing.T) {
+		var i int
+		var s string
+		var is []int
+		var b bool
+		if err := lua.Call("multi", nil, &i, &s, &is, &b); err != nil {
+			t.Fatal(err)
+		}
+		if i != 42 || s != "foo" || len(is) != 3 || is[2] != 3 || !b {
+			t.Fatal()
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		lua.RunString(`function retnil() return nil, nil end`)
+		s := "foo"
+		p := new(int)
+		if err := lua.Call("retnil", nil, &s, &p); err != nil {
+			t.Fatal(err)
+		}
+		if s != "" || p != nil {
+			t.Fatal()
+		}
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		var o struct {
+			I int
+			S string
+		}
+		if err := lua.Call("object", nil, &o); err != nil {
+			t.Fatal(err)
+		}
+		if o.I != 42 || o.S != "foo" {
+			t.Fatal()
+		}
+	})
+
+	t.Run("no return", func(t *testing.T) {
+		if err := lua.Call("nothing", nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("count mismatch", func(t *testing.T) {
+		var i int
+		err := lua.Call("nothing", nil, &i)
+		if !errors.Is(err, ErrCountMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		if err.Error() != "expecting 1 return values, got 0" {
+			t.Fatalf("got %v", err)
+		}
+		err = lua.Call("multi", nil, &i)
+		if !errors.Is(err, ErrCountMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("type mismatch", func(t *testing.T) {
+		var i int
+		err := lua.Call("object", nil, &i)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("bad target", func(t *testing.T) {
+		var i int
+		err := lua.Call("add", []interface{}{1, 2}, i)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var i int
+		err := lua.Call("fail", nil, &i)
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
+
+func TestErrorReturn(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	errFoo := errors.New("foo")
+	lua.RegisterFunction("div", func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errFoo
+		}
+		return a / b, nil
+	})
+	lua.RegisterFunction("check", func(ok bool) error {
+		if !ok {
+			return errFoo
+		}
+		return nil
+	})
+
+	t.Run("nil error", func(t *testing.T) {
+		lua.RunString(`
+			if select('#', div(4, 2)) ~= 1 then error('error not dropped') end
+			if div(4, 2) ~= 2 then error('bad result') end
+			if select('#', check(true)) ~= 0 then error('error not dropped') end
+		`)
+	})
+
+	t.Run("pcall", func(t *testing.T) {
+		lua.RunString(`
+			local ok, err = pcall(div, 1, 0)
+			if ok then error('should fail') end
+			if err ~= 'foo' then error('bad message') end
+		`)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		err := lua.RunStringE(`div(1, 0)`)
+		if !errors.Is(err, errFoo) {
+			t.Fatalf("got %v", err)
+		}
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		err = lua.RunStringE(`
+			local ok, err = pcall(check, false)
+			error(err, 0)
+		`)
+		if !errors.Is(err, errFoo) {
+			t.Fatalf("got %v", err)
+		}
+		err = lua.RunStringE(`error('foo')`)
+		if errors.Is(err, errFoo) {
+			t.Fatal()
+		}
+	})
+}
+
+func TestGoPanic(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RegisterFunction("panic", func() {
+		panic("foo")
+	})
+	lua.RegisterFunction("ok", func() int {
+		return 42
+	})
+
+	t.Run("re-panic", func(t *testing.T) {
+		func() {
+			defer func() {
+				p := recover()
+				if p == nil {
+					t.Fatal()
+				}
+				if s, ok := p.(string); !ok || s != "foo" {
+					t.Fatalf("got %v", p)
+				}
+			}()
+			lua.RunStringE(`panic()`)
+		}()
+		// state still usable
+		lua.RunString(`if ok() ~= 42 then error('bad') end`)
+	})
+
+	t.Run("pcall", func(t *testing.T) {
+		lua.RunString(`
+			local success, err = pcall(panic)
+			if success then error('should fail') end
+			if not string.find(err, 'go panic: foo', 1, true) then error('bad message') end
+			if ok() ~= 42 then error('bad') end
+		`)
+	})
+
+	t.Run("argument panic", func(t *testing.T) {
+		lua.RunString(`
+			local success, err = pcall(ok, 1)
+			if success then error('should fail') end
+		`)
+	})
+}
+
+func TestIntegers(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		function mathType(n)
+			return math.type(n)
+		end
+		function id(n)
+			return n
+		end
+	`)
+
+	t.Run("push", func(t *testing.T) {
+		for _, v := range []interface{}{
+			int(1), int8(1), int16(1), int32(1), int64(1),
+			uint(1), uint8(1), uint16(1), uint32(1), uint64(1),
+		} {
+			var typ string
+			if err := lua.Call("mathType", []interface{}{v}, &typ); err != nil {
+				t.Fatal(err)
+			}
+			if typ != "integer" {
+				t.Fatalf("%T: got %s", v, typ)
+			}
+		}
+		var typ string
+		if err := lua.Call("mathType", []interface{}{1.0}, &typ); err != nil {
+			t.Fatal(err)
+		}
+		if typ != "float" {
+			t.Fatal()
+		}
+		if err := lua.Call("mathType", []interface{}{uint64(math.MaxInt64)}, &typ); err != nil {
+			t.Fatal(err)
+		}
+		if typ != "integer" {
+			t.Fatal()
+		}
+		// not rounded to float
+		if err := lua.Call("mathType", []interface{}{uint64(math.MaxInt64 + 1)}, &typ); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("precision", func(t *testing.T) {
+		for _, n := range []int64{
+			math.MaxInt64,
+			math.MinInt64,
+			1<<53 + 1,
+		} {
+			var ret int64
+			if err := lua.Call("id", []interface{}{n}, &ret); err != nil {
+				t.Fatal(err)
+			}
+			if ret != n {
+				t.Fatalf("expecting %d, got %d", n, ret)
+			}
+		}
+		var u uint64
+		if err := lua.Call("id", []interface{}{uint64(math.MaxInt64)}, &u); err != nil {
+			t.Fatal(err)
+		}
+		if u != math.MaxInt64 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("interface", func(t *testing.T) {
+		var v interface{}
+		if err := lua.Call("id", []interface{}{42}, &v); err != nil {
+			t.Fatal(err)
+		}
+		if i, ok := v.(int64); !ok || i != 42 {
+			t.Fatalf("got %#v", v)
+		}
+		if err := lua.Call("id", []interface{}{42.5}, &v); err != nil {
+			t.Fatal(err)
+		}
+		if f, ok := v.(float64); !ok || f != 42.5 {
+			t.Fatalf("got %#v", v)
+		}
+	})
+
+	t.Run("conversion", func(t *testing.T) {
+		var i int
+		if err := lua.Call("id", []interface{}{42.0}, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != 42 {
+			t.Fatal()
+		}
+		var u8 uint8
+		if err := lua.Call("id", []interface{}{255.0}, &u8); err != nil {
+			t.Fatal(err)
+		}
+		if u8 != 255 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, c := range []struct {
+			arg    interface{}
+			target interface{}
+		}{
+			{4.2, new(int)},
+			{4.2, new(uint)},
+			{1e100, new(int64)},
+			{1e100, new(uint64)},
+			{256, new(uint8)},
+			{-1, new(uint8)},
+			{-1, new(uint64)},
+			{-129, new(int8)},
+			{math.MaxInt32 + 1, new(int32)},
+			{math.Inf(1), new(int)},
+			{math.NaN(), new(int)},
+		} {
+			err := lua.Call("id", []interface{}{c.arg}, c.target)
+			if !errors.Is(err, ErrTypeMismatch) {
+				t.Fatalf("%v %T: got %v", c.arg, c.target, err)
+			}
+		}
+
+		lua.RegisterFunction("byte", func(b uint8) {})
+		err := lua.RunStringE(`byte(1.5)`)
+		if err == nil || !strings.Contains(err.Error(), "number 1.5 cannot be represented") {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
+
+func TestBinaryStrings(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		function id(s)
+			return s
+		end
+		function len(s)
+			return #s
+		end
+	`)
+
+	str := "foo\x00bar\xff"
+	var s string
+	if err := lua.Call("id", []interface{}{str}, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != str {
+		t.Fatalf("got %q", s)
+	}
+	var n int
+	if err := lua.Call("len", []interface{}{str}, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(str) {
+		t.Fatal()
+	}
+
+	// source
+	lua.RunString("src = [[" + str + "]]")
+	if err := lua.GetGlobal("src", &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != str {
+		t.Fatalf("got %q", s)
+	}
+
+	// bytes
+	bs := []byte{0, 1, 2, 0, 255}
+	if err := lua.Call("len", []interface{}{bs}, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(bs) {
+		t.Fatal()
+	}
+	var ret []byte
+	if err := lua.Call("id", []interface{}{bs}, &ret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, bs) {
+		t.Fatalf("got %v", ret)
+	}
+	if err := lua.Call("id", []interface{}{[]byte{}}, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "" {
+		t.Fatal()
+	}
+
+	// go functions
+	lua.RegisterFunction("concat", func(a []byte, b string) []byte {
+		return append(a, b...)
+	})
+	lua.RunString(`
+		local s = concat('\0a', 'b\0')
+		assert(type(s) == 'string')
+		assert(s == '\0ab\0')
+	`)
+
+	// error messages
+	err := lua.RunStringE(`error('foo\0bar', 0)`)
+	if err == nil || err.Error() != "foo\x00bar" {
+		t.Fatalf("got %q", err)
+	}
+}
+
+func TestTableMode(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		function get(name)
+			local values = {
+				sequence = {1, 'foo', true},
+				reversed = (function()
+					local t = {}
+					for i = 3, 1, -1 do
+						t[i] = i
+					end
+					return t
+				end)(),
+				record = {
+					foo = 'bar',
+					list = {4.5, {a = 1}},
+				},
+				mixed = {1, 2, foo = 'bar'},
+				sparse = {[1] = 1, [3] = 3},
+				empty = {},
+				tableKey = {[{}] = 1},
+			}
+			return values[name]
+		end
+	`)
+
+	get := func(name string) (interface{}, error) {
+		var v interface{}
+		err := lua.Call("get", []interface{}{name}, &v)
+		return v, err
+	}
+	check := func(name string, expected interface{}) {
+		t.Helper()
+		v, err := get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("%s: got %#v", name, v)
+		}
+	}
+
+	t.Run("auto", func(t *testing.T) {
+		lua.TableMode = TableAuto
+		check("sequence", []interface{}{int64(1), "foo", true})
+		check("reversed", []interface{}{int64(1), int64(2), int64(3)})
+		check("record", map[string]interface{}{
+			"foo": "bar",
+			"list": []interface{}{
+				4.5,
+				map[string]interface{}{
+					"a": int64(1),
+				},
+			},
+		})
+		check("mixed", map[interface{}]interface{}{
+			int64(1): int64(1),
+			int64(2): int64(2),
+			"foo":    "bar",
+		})
+		check("sparse", map[interface{}]interface{}{
+			int64(1): int64(1),
+			int64(3): int64(3),
+		})
+		check("empty", map[string]interface{}{})
+	})
+
+	t.Run("map", func(t *testing.T) {
+		lua.TableMode = TableAsMap
+		defer func() {
+			lua.TableMode = TableAuto
+		}()
+		check("sequence", map[interface{}]interface{}{
+			int64(1): int64(1),
+			int64(2): "foo",
+			int64(3): true,
+		})
+		check("record", map[interface{}]interface{}{
+			"foo": "bar",
+			"list": map[interface{}]interface{}{
+				int64(1): 4.5,
+				int64(2): map[interface{}]interface{}{
+					"a": int64(1),
+				},
+			},
+		})
+	})
+
+	t.Run("json", func(t *testing.T) {
+		lua.TableMode = TableJSON
+		defer func() {
+			lua.TableMode = TableAuto
+		}()
+		check("sequence", []interface{}{int64(1), "foo", true})
+		check("mixed", map[string]interface{}{
+			"1":   int64(1),
+			"2":   int64(2),
+			"foo": "bar",
+		})
+		check("sparse", map[string]interface{}{
+			"1": int64(1),
+			"3": int64(3),
+		})
+		check("empty", map[string]interface{}{})
+		if _, err := get("tableKey"); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("go function", func(t *testing.T) {
+		lua.RegisterFunction("data", func(v interface{}) {
+			if !reflect.DeepEqual(v, []interface{}{
+				map[string]interface{}{
+					"id": int64(1),
+				},
+			}) {
+				t.Fatalf("got %#v", v)
+			}
+		})
+		lua.RunString(`data({{id = 1}})`)
+	})
+
+	t.Run("deep nesting", func(t *testing.T) {
+		// deeper than the free stack slots of a go function
+		lua.RunString(`
+			deep = {}
+			local t = deep
+			for i = 1, 500 do
+				t[1] = {}
+				t = t[1]
+			end
+		`)
+		type nested []nested
+		depth := 0
+		lua.RegisterFunction("depth", func(v interface{}) {
+			depth = 0
+			for v != nil {
+				depth++
+				switch inner := v.(type) {
+				case []interface{}:
+					v = inner[0]
+				case map[interface{}]interface{}:
+					v = inner[int64(1)]
+				default:
+					v = nil
+				}
+			}
+		})
+		lua.RegisterFunction("nestedDepth", func(v nested) {
+			depth = 0
+			for len(v) > 0 {
+				depth++
+				v = v[0]
+			}
+		})
+		lua.RunString(`depth(deep)`)
+		if depth != 501 {
+			t.Fatalf("got %d", depth)
+		}
+		lua.TableMode = TableAsMap
+		lua.RunString(`depth(deep)`)
+		lua.TableMode = TableAuto
+		if depth != 501 {
+			t.Fatalf("got %d", depth)
+		}
+		lua.RunString(`nestedDepth(deep)`)
+		if depth != 500 {
+			t.Fatalf("got %d", depth)
+		}
+	})
+}
+
+func TestPointers(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	type Config struct {
+		Name string
+	}
+	point := &testPoint{X: 1}
+	lua.SetGlobalObject("point", point)
+
+	t.Run("nil", func(t *testing.T) {
+		called := false
+		lua.RegisterFunction("nilConfig", func(c *Config, cc **Config) {
+			called = true
+			if c != nil || cc != nil {
+				t.Fatal()
+			}
+		})
+		lua.RunString(`nilConfig(nil, nil)`)
+		if !called {
+			t.Fatal()
+		}
+		// set target
+		c := &Config{}
+		if err := lua.GetGlobal("undefined", &c); err != nil {
+			t.Fatal(err)
+		}
+		if c != nil {
+			t.Fatal()
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var configs []*Config
+		lua.RegisterFunction("config", func(c *Config, cc **Config) {
+			if c == nil || c.Name != "foo" {
+				t.Fatal()
+			}
+			if cc == nil || *cc == nil || (*cc).Name != "bar" {
+				t.Fatal()
+			}
+			configs = append(configs, c)
+		})
+		lua.RunString(`
+			local c = {Name = 'foo'}
+			config(c, {Name = 'bar'})
+			config(c, {Name = 'bar'})
+		`)
+		if len(configs) != 2 || configs[0] == configs[1] {
+			t.Fatal()
+		}
+	})
+
+	t.Run("userdata", func(t *testing.T) {
+		lua.RegisterFunction("point", func(p *testPoint, pp **testPoint, v testPoint) {
+			if p != point {
+				t.Fatal()
+			}
+			if pp == nil || *pp != point {
+				t.Fatal()
+			}
+			if v.X != 1 {
+				t.Fatal()
+			}
+		})
+		lua.RunString(`point(point, point, point)`)
+		lua.RegisterFunction("notPoint", func(c *Config) {})
+		if err := lua.RunStringE(`notPoint(point)`); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("slice", func(t *testing.T) {
+		lua.RegisterFunction("points", func(ps []*testPoint) {
+			if len(ps) != 2 {
+				t.Fatal()
+			}
+			if ps[0] == point || ps[0].X != 2 {
+				t.Fatal()
+			}
+			if ps[1] != point {
+				t.Fatal()
+			}
+		})
+		lua.RunString(`points({{X = 2}, point})`)
+	})
+
+	t.Run("map", func(t *testing.T) {
+		lua.RegisterFunction("pointMap", func(m map[string]*testPoint) {
+			if len(m) != 2 {
+				t.Fatal()
+			}
+			if m["a"] == point || m["a"].Y != 3 {
+				t.Fatal()
+			}
+			if m["b"] != point {
+				t.Fatal()
+			}
+		})
+		lua.RunString(`pointMap({a = {Y = 3}, b = point})`)
+	})
+
+	t.Run("struct field", func(t *testing.T) {
+		type Shape struct {
+			Center *testPoint
+			Corner **testPoint
+			Origin *testPoint
+			Config *Config
+		}
+		lua.RegisterFunction("shape", func(s Shape) {
+			if s.Center != point {
+				t.Fatal()
+			}
+			if s.Corner == nil || *s.Corner == nil || (*s.Corner).X != 4 {
+				t.Fatal()
+			}
+			if s.Origin != nil {
+				t.Fatal()
+			}
+			if s.Config == nil || s.Config.Name != "foo" {
+				t.Fatal()
+			}
+		})
+		lua.RunString(`shape({Center = point, Corner = {X = 4}, Config = {Name = 'foo'}})`)
+	})
+
+	t.Run("results", func(t *testing.T) {
+		lua.RunString(`
+			function getPoint() return point end
+			function getNil() return nil end
+		`)
+		var p *testPoint
+		if err := lua.Call("getPoint", nil, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p != point {
+			t.Fatal()
+		}
+		if err := lua.Call("getNil", nil, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p != nil {
+			t.Fatal()
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+void set_hook(lua_State*, int, int);
+*/
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// Limits are the quotas enforced on calls into lua, zero fields are unlimited.
+// A call is an outermost entry into lua from go, like RunString, Call or a Ref method;
+// lua code run by go functions it calls belongs to it.
+// An exceeded limit aborts the call with a *LuaError of ErrLimit.
+// Finalizers run by the garbage collector outside calls, like in Close, are not limited, see NewSandbox.
+type Limits struct {
+	// MaxInstructions is the maximum number of VM instructions of a call
+	MaxInstructions int64
+	// MaxTotalInstructions is the maximum number of VM instructions in the lifetime of the state
+	MaxTotalInstructions int64
+	// MaxCallDepth is the maximum number of active function frames
+	MaxCallDepth int
+	// MaxGoCalls is the maximum number of invocations of registered go functions in a call
+	MaxGoCalls int64
+}
+
+// Usage is the resource usage of the last call and of the lifetime of the state.
+// Instructions are counted by a hook run every 1000 instructions at most,
+// so counts are rounded down to the last run of the hook.
+type Usage struct {
+	Instructions      int64
+	TotalInstructions int64
+	GoCalls           int64
+	TotalGoCalls      int64
+	// Memory and PeakMemory are the live and peak allocated bytes, only counted for states created by NewWithOptions
+	Memory     int64
+	PeakMemory int64
+}
+
+// hookInterval is the maximum number of instructions between runs of the hook
+const hookInterval = 1000
+
+// luaHandleKey is the registry key of the handle of the Lua, used by the hook
+const luaHandleKey = "lgo.lua"
+
+// Usage returns the resource usage, see Usage
+func (l *Lua) Usage() Usage {
+	usage := l.usage
+	if l.alloc != nil {
+		usage.Memory = int64(l.alloc.used)
+		usage.PeakMemory = int64(l.alloc.peak)
+	}
+	return usage
+}
+
+// beginCall resets the usage of the call and installs the hook
+func (l *Lua) beginCall() {
+	l.usage.Instructions = 0
+	l.usage.GoCalls = 0
+	l.clearRaised()
+	l.setHook(l.State, l.hookCount())
+}
+
+// endCall removes the hook
+func (l *Lua) endCall() {
+	C.set_hook(l.State, 0, 0)
+	l.hookInstructions = 0
+	l.checkMemoryThreshold()
+}
+
+// hookCount returns the number of instructions to run before the next run of the hook
+func (l *Lua) hookCount() int64 {
+	count := int64(hookInterval)
+	if max := l.Limits.MaxInstructions; max > 0 && max-l.usage.Instructions < count {
+		count = max - l.usage.Instructions
+	}
+	if max := l.Limits.MaxTotalInstructions; max > 0 && max-l.usage.TotalInstructions < count {
+		count = max - l.usage.TotalInstructions
+	}
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+func (l *Lua) setHook(state *C.lua_State, count int64) {
+	mask := C.LUA_MASKCOUNT
+	if l.Limits.MaxCallDepth > 0 {
+		mask |= C.LUA_MASKCALL
+	}
+	l.hookInstructions = count
+	C.set_hook(state, C.int(mask), C.int(count))
+}
+
+//export hook
+func hook(state *C.lua_State, _handle uint64, event C.int) (ret C.int) {
+	l := cgo.Handle(_handle).Value().(*Lua)
+	if l.calls == 0 {
+		// left in a coroutine created during a finished call
+		C.set_hook(state, 0, 0)
+		return 0
+	}
+	defer l.enter(state)()
+	// a go panic must not unwind through lua frames
+	defer func() {
+		if p := recover(); p != nil {
+			ret = C.int(l.raiseRecovered(p))
+		}
+	}()
+
+	switch event {
+	case C.LUA_HOOKCALL:
+		var ar C.lua_Debug
+		if l.Limits.MaxCallDepth > 0 && C.lua_getstack(state, C.int(l.Limits.MaxCallDepth), &ar) != 0 {
+			return C.int(l.raiseOn(state, ErrLimit, "call depth limit exceeded"))
+		}
+		return 0
+	case C.LUA_HOOKTAILCALL:
+		// a tail call reuses the frame
+		return 0
+	}
+
+	l.usage.Instructions += l.hookInstructions
+	l.usage.TotalInstructions += l.hookInstructions
+	l.checkMemoryThreshold()
+	// once aborted, raise at every instruction until the call returns, even if the script catches the error
+	if l.ctx != nil {
+		if err := l.ctx.Err(); err != nil {
+			l.setHook(state, 1)
+			return C.int(l.raiseOn(state, err, err.Error()))
+		}
+	}
+	l.setHook(state, l.hookCount())
+	if max := l.Limits.MaxInstructions; max > 0 && l.usage.Instructions >= max {
+		return C.int(l.raiseOn(state, ErrLimit, "instruction limit exceeded"))
+	}
+	if max := l.Limits.MaxTotalInstructions; max > 0 && l.usage.TotalInstructions >= max {
+		return C.int(l.raiseOn(state, ErrLimit, "total instruction limit exceeded"))
+	}
+	return 0
+}
+
+// countGoCall counts an invocation of a registered go function.
+// It returns -1 after pushing the error object if the limit is exceeded.
+func (l *Lua) countGoCall() int {
+	l.usage.GoCalls++
+	l.usage.TotalGoCalls++
+	if max := l.Limits.MaxGoCalls; max > 0 && l.usage.GoCalls > max {
+		return l.raiseOn(l.State, ErrLimit, "go call limit exceeded")
+	}
+	return 0
+}
+
+// raiseOn pushes msg onto state as the error object of err, like raiseError
+func (l *Lua) raiseOn(state *C.lua_State, err error, msg string) int {
+	l.raisedError = err
+	l.raisedMessage = msg
+	C.lua_checkstack(state, 1)
+	cMsg := C.CString(msg)
+	C.lua_pushlstring(state, cMsg, C.size_t(len(msg)))
+	C.free(unsafe.Pointer(cMsg))
+	return -1
+}
@@ -0,0 +1,159 @@
+package lgo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("usage", func(t *testing.T) {
+		lua.RegisterFunction("nop", func() {})
+		lua.RunString(`
+			for i = 1, 10000 do
+				nop()
+			end
+		`)
+		usage := lua.Usage()
+		if usage.Instructions < 10000 || usage.GoCalls != 10000 {
+			t.Fatalf("got %+v", usage)
+		}
+		lua.RunString(`nop()`)
+		usage2 := lua.Usage()
+		if usage2.GoCalls != 1 ||
+			usage2.TotalGoCalls != 10001 ||
+			usage2.TotalInstructions < usage.TotalInstructions {
+			t.Fatalf("got %+v", usage2)
+		}
+	})
+
+	t.Run("instructions", func(t *testing.T) {
+		lua.Limits = Limits{
+			MaxInstructions: 10000,
+		}
+		defer func() {
+			lua.Limits = Limits{}
+		}()
+		err := lua.RunStringE(`
+			while true do
+				pcall(function()
+					while true do end
+				end)
+			end
+		`)
+		if !errors.Is(err, ErrLimit) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(err.Error(), "instruction limit exceeded") {
+			t.Fatalf("got %v", err)
+		}
+		// raised again at each instruction after the first error
+		if n := lua.Usage().Instructions; n < 10000 || n > 10010 {
+			t.Fatalf("got %d", n)
+		}
+		// per call
+		if err := lua.RunStringE(`local a = 1`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("total instructions", func(t *testing.T) {
+		lua.Limits = Limits{
+			MaxTotalInstructions: lua.Usage().TotalInstructions + 5000,
+		}
+		defer func() {
+			lua.Limits = Limits{}
+		}()
+		code := `for i = 1, 1000 do end`
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = lua.RunStringE(code)
+		}
+		if !errors.Is(err, ErrLimit) {
+			t.Fatalf("got %v", err)
+		}
+		if err := lua.RunStringE(code); !errors.Is(err, ErrLimit) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("call depth", func(t *testing.T) {
+		lua.RunString(`
+			function recurse(n)
+				if n == 0 then
+					return 0
+				end
+				return 1 + recurse(n - 1)
+			end
+		`)
+		lua.Limits = Limits{
+			MaxCallDepth: 50,
+		}
+		defer func() {
+			lua.Limits = Limits{}
+		}()
+		var n int
+		if err := lua.Call("recurse", []interface{}{10}, &n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 10 {
+			t.Fatal()
+		}
+		err := lua.Call("recurse", []interface{}{100}, &n)
+		if !errors.Is(err, ErrLimit) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(err.Error(), "call depth limit exceeded") {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("tail calls", func(t *testing.T) {
+		lua.RunString(`
+			function loop(n)
+				if n == 0 then
+					return 0
+				end
+				return loop(n - 1)
+			end
+		`)
+		lua.Limits = Limits{
+			MaxCallDepth:    50,
+			MaxInstructions: 100000,
+		}
+		defer func() {
+			lua.Limits = Limits{}
+		}()
+		var n int
+		if err := lua.Call("loop", []interface{}{1000}, &n); err != nil {
+			t.Fatal(err)
+		}
+		// about 6 instructions per call
+		if i := lua.Usage().Instructions; i > 10000 {
+			t.Fatalf("got %d", i)
+		}
+	})
+
+	t.Run("go calls", func(t *testing.T) {
+		lua.Limits = Limits{
+			MaxGoCalls: 3,
+		}
+		defer func() {
+			lua.Limits = Limits{}
+		}()
+		if err := lua.RunStringE(`nop() nop() nop()`); err != nil {
+			t.Fatal(err)
+		}
+		err := lua.RunStringE(`nop() nop() nop() nop()`)
+		if !errors.Is(err, ErrLimit) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(err.Error(), "go call limit exceeded") {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+#include <stdlib.h>
+#include "lgo.h"
+
+lua_State* new_state(lgo_alloc*);
+*/
+import "C"
+
+import "unsafe"
+
+// Options configures a Lua created by NewWithOptions
+type Options struct {
+	// MemoryLimit is the maximum number of bytes allocated by the state, 0 for no limit.
+	// It is enforced while lua code runs, a failed allocation raises a lua memory error,
+	// returned as a *LuaError of ErrMemory.
+	MemoryLimit int64
+	// MemoryThreshold is the soft limit of allocated bytes, 0 for none
+	MemoryThreshold int64
+	// OnMemoryThreshold is called with the allocated bytes after they cross MemoryThreshold upward,
+	// by the hook while lua code runs or when the call returns
+	OnMemoryThreshold func(used int64)
+}
+
+// NewWithOptions creates a Lua like New, with an allocator accounting the memory of the state.
+// Allocated memory is reported by Usage.
+func NewWithOptions(options Options) *Lua {
+	return newWithOptions(options, func(state *C.lua_State) {
+		C.luaL_openlibs(state)
+	})
+}
+
+// newWithOptions creates a Lua with the accounting allocator, libraries are opened by openLibs
+func newWithOptions(options Options, openLibs func(*C.lua_State)) *Lua {
+	alloc := (*C.lgo_alloc)(C.calloc(1, C.sizeof_lgo_alloc))
+	alloc.limit = C.size_t(options.MemoryLimit)
+	alloc.threshold = C.size_t(options.MemoryThreshold)
+	state := C.new_state(alloc)
+	if state == nil { //NOCOVER
+		C.free(unsafe.Pointer(alloc))
+		panic("lua state create error")
+	}
+	openLibs(state)
+	lua := newLua(state)
+	lua.alloc = alloc
+	lua.onMemoryThreshold = options.OnMemoryThreshold
+	return lua
+}
+
+// checkMemoryThreshold calls onMemoryThreshold if the threshold was crossed since the last check
+func (l *Lua) checkMemoryThreshold() {
+	if l.alloc == nil || l.alloc.threshold_crossed == 0 {
+		return
+	}
+	l.alloc.threshold_crossed = 0
+	if l.onMemoryThreshold != nil {
+		l.onMemoryThreshold(int64(l.alloc.used))
+	}
+}
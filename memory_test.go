@@ -0,0 +1,66 @@
+package lgo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	var crossed []int64
+	lua := NewWithOptions(Options{
+		MemoryLimit:     8 << 20,
+		MemoryThreshold: 4 << 20,
+		OnMemoryThreshold: func(used int64) {
+			crossed = append(crossed, used)
+		},
+	})
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	usage := lua.Usage()
+	if usage.Memory <= 0 || usage.PeakMemory < usage.Memory {
+		t.Fatalf("got %+v", usage)
+	}
+
+	err := lua.RunStringE(`
+		local t = {}
+		for i = 1, 1e9 do
+			t[i] = {}
+		end
+	`)
+	if !errors.Is(err, ErrMemory) {
+		t.Fatalf("got %v", err)
+	}
+	if len(crossed) != 1 || crossed[0] < 4<<20 {
+		t.Fatalf("got %v", crossed)
+	}
+	usage = lua.Usage()
+	if usage.PeakMemory > 8<<20 || usage.PeakMemory < 4<<20 {
+		t.Fatalf("got %+v", usage)
+	}
+
+	// still usable
+	lua.RunString(`collectgarbage()`)
+	if lua.Usage().Memory >= 4<<20 {
+		t.Fatalf("got %+v", lua.Usage())
+	}
+	lua.RegisterFunction("rep", strings.Repeat)
+	var s string
+	if err := lua.Call("rep", []interface{}{"foo", 3}, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "foofoofoo" {
+		t.Fatal()
+	}
+	// results of go functions are not limited, but keeping them is
+	err = lua.RunStringE(`
+		local t = {}
+		for i = 1, 100 do
+			t[i] = rep('x', 1 << 20)
+		end
+	`)
+	if !errors.Is(err, ErrMemory) {
+		t.Fatalf("got %v", err)
+	}
+}
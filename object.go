@@ -0,0 +1,185 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+#include <stdint.h>
+
+void push_function(lua_State*, int64_t);
+void setup_object_metatable(lua_State*, const char*);
+void push_object(lua_State*, int64_t, int);
+int64_t to_object(lua_State*, int);
+*/
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+	"runtime/cgo"
+
+	"github.com/reusee/sb"
+)
+
+// object is a go value wrapped in lua full userdata
+type object struct {
+	lua *Lua
+	// always a non-nil pointer
+	value reflect.Value
+}
+
+type methodKey struct {
+	t    reflect.Type
+	name string
+}
+
+// PushObject pushes v onto the stack as userdata.
+// Exported fields and methods of v are accessible from lua, and fields can be set.
+// If v is not a pointer, a copy of v is wrapped.
+func (l *Lua) PushObject(v interface{}) {
+	l.checkOpen()
+	l.pushObject(reflect.ValueOf(v))
+}
+
+func (l *Lua) pushObject(value reflect.Value) {
+	if !value.IsValid() || value.Kind() == reflect.Ptr && value.IsNil() {
+		C.lua_pushnil(l.State)
+		return
+	}
+	if value.Kind() != reflect.Ptr {
+		ptr := reflect.New(value.Type())
+		ptr.Elem().Set(value)
+		value = ptr
+	}
+	handle := l.newHandle(&object{
+		lua:   l,
+		value: value,
+	})
+	C.push_object(l.State, C.int64_t(handle), l.objectMetatable(value.Type()))
+}
+
+// SetGlobalObject sets the global name, which may be dotted like in RegisterFunction, to v wrapped as userdata
+func (l *Lua) SetGlobalObject(name string, v interface{}) {
+	l.checkOpen()
+	name = l.pushNamespace(name)
+	C.lua_pushstring(l.State, cstr(name))
+	l.PushObject(v)
+	C.lua_rawset(l.State, -3)
+	C.lua_settop(l.State, -2)
+}
+
+// objectMetatable returns the registry reference of the metatable for objects of type t
+func (l *Lua) objectMetatable(t reflect.Type) C.int {
+	if ref, ok := l.metatables[t]; ok {
+		return ref
+	}
+	C.lua_createtable(l.State, 0, 0)
+	C.setup_object_metatable(l.State, cstr(t.String()))
+	l.setMetamethods(t)
+	ref := C.luaL_ref(l.State, C.LUA_REGISTRYINDEX)
+	l.metatables[t] = ref
+	return ref
+}
+
+// toObject returns the object at num, or nil if the value is not an object
+func (l *Lua) toObject(num C.int) *object {
+	handle := C.to_object(l.State, num)
+	if handle == 0 {
+		return nil
+	}
+	return cgo.Handle(handle).Value().(*object)
+}
+
+// method returns the handle of the function calling the method name of t with the receiver as the first argument
+func (l *Lua) method(t reflect.Type, name string) (cgo.Handle, bool) {
+	key := methodKey{t, name}
+	if handle, ok := l.methods[key]; ok {
+		return handle, true
+	}
+	method, ok := t.MethodByName(name)
+	if !ok {
+		return 0, false
+	}
+	handle := l.newHandle(l.newFunction(
+		fmt.Sprintf("%v.%s", t, name),
+		method.Func.Interface(),
+	))
+	l.methods[key] = handle
+	return handle, true
+}
+
+func (o *object) field(name string) (reflect.Value, bool) {
+	value := reflect.Indirect(o.value)
+	if value.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	field := o.lua.lookupField(o.lua.structFields(value.Type()), name)
+	if field == nil {
+		return reflect.Value{}, false
+	}
+	return value.FieldByIndex(field.index), true
+}
+
+//export objectIndex
+func objectIndex(state *C.lua_State, _handle uint64) (ret int) {
+	o := cgo.Handle(_handle).Value().(*object)
+	l := o.lua
+	defer l.enter(state)()
+	defer func() {
+		if p := recover(); p != nil {
+			ret = l.raiseRecovered(p)
+		}
+	}()
+	if C.lua_type(l.State, 2) != C.LUA_TSTRING {
+		C.lua_pushnil(l.State)
+		return 1
+	}
+	name := l.toString(2)
+	if field, ok := o.field(name); ok {
+		ce(sb.Copy(
+			l.marshal(field),
+			pushValue(l, nil),
+		))
+		return 1
+	}
+	if handle, ok := l.method(o.value.Type(), name); ok {
+		C.push_function(l.State, C.int64_t(handle))
+		return 1
+	}
+	C.lua_pushnil(l.State)
+	return 1
+}
+
+//export objectNewIndex
+func objectNewIndex(state *C.lua_State, _handle uint64) (ret int) {
+	o := cgo.Handle(_handle).Value().(*object)
+	l := o.lua
+	defer l.enter(state)()
+	defer func() {
+		if p := recover(); p != nil {
+			ret = l.raiseRecovered(p)
+		}
+	}()
+	var name string
+	if C.lua_type(l.State, 2) == C.LUA_TSTRING {
+		name = l.toString(2)
+	}
+	field, ok := o.field(name)
+	if !ok {
+		return l.raiseError(fmt.Errorf("no field %s in %v", name, o.value.Type()))
+	}
+	value := reflect.New(field.Type())
+	if err := l.decode(3, value.Interface()); err != nil {
+		return l.raiseError(err)
+	}
+	field.Set(value.Elem())
+	return 0
+}
+
+//export objectGC
+func objectGC(_handle uint64) {
+	handle := cgo.Handle(_handle)
+	o := handle.Value().(*object)
+	delete(o.lua.handles, handle)
+	handle.Delete()
+}
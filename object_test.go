@@ -0,0 +1,142 @@
+package lgo
+
+import (
+	"errors"
+	"testing"
+)
+
+type testPoint struct {
+	X, Y int
+	name string
+}
+
+func (p *testPoint) Move(dx, dy int) {
+	p.X += dx
+	p.Y += dy
+}
+
+func (p testPoint) Sum() int {
+	return p.X + p.Y
+}
+
+func TestObject(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("fields and methods", func(t *testing.T) {
+		p := &testPoint{X: 1, Y: 2}
+		lua.SetGlobalObject("p", p)
+		lua.RunString(`
+			if p.X ~= 1 or p.Y ~= 2 then error('bad fields') end
+			if p.name ~= nil then error('unexported field') end
+			if p:Sum() ~= 3 then error('bad sum') end
+			p:Move(1, 1)
+			p.X = p.X * 10
+		`)
+		if p.X != 20 || p.Y != 3 {
+			t.Fatalf("got %+v", p)
+		}
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		p := testPoint{X: 1}
+		lua.SetGlobalObject("p2", p)
+		lua.RunString(`
+			p2:Move(1, 0)
+			if p2.X ~= 2 then error('not moved') end
+		`)
+		if p.X != 1 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		lua.SetGlobalObject("geo.points.origin", &testPoint{})
+		lua.RunString(`
+			if geo.points.origin:Sum() ~= 0 then error('bad') end
+		`)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		p := &testPoint{}
+		lua.SetGlobalObject("p3", p)
+		var got *testPoint
+		var gotValue testPoint
+		lua.RegisterFunction("take", func(ptr *testPoint, value testPoint) {
+			got = ptr
+			gotValue = value
+		})
+		lua.RunString(`p3.X = 42 take(p3, p3)`)
+		if got != p {
+			t.Fatal()
+		}
+		if gotValue.X != 42 {
+			t.Fatal()
+		}
+		var ret *testPoint
+		if err := lua.Call("getP3", nil, &ret); err == nil {
+			t.Fatal()
+		}
+		lua.RunString(`function getP3() return p3 end`)
+		if err := lua.Call("getP3", nil, &ret); err != nil {
+			t.Fatal(err)
+		}
+		if ret != p {
+			t.Fatal()
+		}
+	})
+
+	t.Run("push from function", func(t *testing.T) {
+		lua.RegisterFunction("newPoint", func(c *CallContext) int {
+			var x int
+			if err := c.Arg(1, &x); err != nil {
+				return c.Error("%v", err)
+			}
+			c.PushObject(&testPoint{X: x})
+			return 1
+		})
+		lua.RunString(`
+			local p = newPoint(3)
+			p:Move(1, 0)
+			if p.X ~= 4 then error('bad') end
+		`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		lua.SetGlobalObject("p4", &testPoint{})
+		err := lua.RunStringE(`p4.Z = 1`)
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if err.Error() != "no field Z in *lgo.testPoint" {
+			t.Fatalf("got %v", err)
+		}
+		err = lua.RunStringE(`p4.X = 'foo'`)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		lua.RunString(`
+			if p4.Z ~= nil then error('not nil') end
+			if getmetatable(p4) ~= '*lgo.testPoint' then error('bad metatable') end
+		`)
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		lua.RunString(`collectgarbage()`)
+		n := len(lua.handles)
+		for i := 0; i < 10; i++ {
+			lua.SetGlobalObject("tmp", &testPoint{X: i})
+		}
+		if len(lua.handles) != n+10 {
+			t.Fatalf("got %d", len(lua.handles))
+		}
+		lua.RunString(`
+			tmp = nil
+			collectgarbage()
+		`)
+		if len(lua.handles) != n {
+			t.Fatalf("got %d, expected %d", len(lua.handles), n)
+		}
+	})
+}
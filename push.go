@@ -3,6 +3,8 @@ package lgo
 import (
 	"fmt"
 	"io"
+	"math"
+	"reflect"
 	"unsafe"
 
 	"github.com/reusee/sb"
@@ -12,9 +14,124 @@ import (
 #include <lua.h>
 #include <lualib.h>
 #include <lauxlib.h>
+#include <stdlib.h>
 */
 import "C"
 
+// marshalValue is the sb marshal function used for pushing, values of registered types are emitted as objects
+func (l *Lua) marshalValue(ctx sb.Ctx, value reflect.Value, cont proc) proc {
+	if value.IsValid() &&
+		(value.Type() == refPtrType || value.Type() == tablePtrType || value.Type() == envPtrType) &&
+		!value.IsNil() {
+		return func() (*sb.Token, proc, error) {
+			return &sb.Token{
+				Kind:  kindValue,
+				Value: value,
+			}, cont, nil
+		}
+	}
+	if value.IsValid() {
+		t := value.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if _, ok := l.types[t]; ok {
+			if value.Kind() == reflect.Ptr && value.IsNil() {
+				return sb.MarshalValue(ctx, value, cont)
+			}
+			return func() (*sb.Token, proc, error) {
+				return &sb.Token{
+					Kind:  kindValue,
+					Value: value,
+				}, cont, nil
+			}
+		}
+	}
+	if value.IsValid() && !(value.Kind() == reflect.Ptr && value.IsNil()) {
+		if converter := l.converter(value.Type()); converter != nil && converter.ToLua != nil {
+			v, err := converter.ToLua(value)
+			if err != nil {
+				return func() (*sb.Token, proc, error) {
+					return nil, nil, err
+				}
+			}
+			return l.marshalValue(ctx, reflect.ValueOf(v), cont)
+		}
+	}
+	if value.IsValid() && value.Kind() == reflect.Struct {
+		return l.marshalStruct(ctx, value, cont)
+	}
+	return sb.MarshalValue(ctx, value, cont)
+}
+
+// marshalStruct emits the fields of a struct value as an object, named by structFields
+func (l *Lua) marshalStruct(ctx sb.Ctx, value reflect.Value, cont proc) proc {
+	fields := l.structFields(value.Type()).list
+	i := 0
+	var marshalFields proc
+	marshalFields = func() (*sb.Token, proc, error) {
+		for i < len(fields) {
+			field := fields[i]
+			i++
+			fieldValue := value.FieldByIndex(field.index)
+			if field.omitEmpty && isEmptyValue(fieldValue) {
+				continue
+			}
+			return &sb.Token{
+				Kind:  sb.KindString,
+				Value: field.name,
+			}, ctx.Marshal(ctx, fieldValue, marshalFields), nil
+		}
+		return &sb.Token{
+			Kind: sb.KindObjectEnd,
+		}, cont, nil
+	}
+	return func() (*sb.Token, proc, error) {
+		return &sb.Token{
+			Kind: sb.KindObject,
+		}, marshalFields, nil
+	}
+}
+
+func (l *Lua) marshal(value reflect.Value) *proc {
+	marshaler := l.marshalValue(
+		sb.Ctx{
+			Marshal: l.marshalValue,
+		},
+		value,
+		nil,
+	)
+	return &marshaler
+}
+
+// push pushes v onto the stack
+func (l *Lua) push(v interface{}) {
+	ce(sb.Copy(
+		l.marshal(reflect.ValueOf(v)),
+		pushValue(l, nil),
+	))
+}
+
+// pushGoValue pushes a go value emitted by marshalValue
+func (l *Lua) pushGoValue(value reflect.Value) {
+	if ref, ok := value.Interface().(*Ref); ok {
+		if ref.lua != l {
+			l.Panic("ref of another lua state")
+		}
+		ref.Push()
+		return
+	}
+	if table, ok := value.Interface().(*Table); ok {
+		l.pushGoValue(reflect.ValueOf(table.Ref))
+		return
+	}
+	if env, ok := value.Interface().(*Env); ok {
+		l.pushGoValue(reflect.ValueOf(env.Ref))
+		return
+	}
+	l.pushObject(value)
+}
+
 func pushValue(l *Lua, cont sink) sink {
 	return func(token *sb.Token) (sink, error) {
 		if token == nil { // NOCOVER
@@ -34,29 +151,36 @@ func pushValue(l *Lua, cont sink) sink {
 			}
 
 		case sb.KindString:
-			C.lua_pushstring(l.State, C.CString(token.Value.(string)))
+			l.pushString(token.Value.(string))
+
+		case sb.KindBytes:
+			l.pushBytes(token.Value.([]byte))
 
 		case sb.KindInt:
-			C.lua_pushnumber(l.State, C.lua_Number(C.longlong(token.Value.(int))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(int)))
 		case sb.KindInt8:
-			C.lua_pushnumber(l.State, C.lua_Number(C.longlong(token.Value.(int8))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(int8)))
 		case sb.KindInt16:
-			C.lua_pushnumber(l.State, C.lua_Number(C.longlong(token.Value.(int16))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(int16)))
 		case sb.KindInt32:
-			C.lua_pushnumber(l.State, C.lua_Number(C.longlong(token.Value.(int32))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(int32)))
 		case sb.KindInt64:
-			C.lua_pushnumber(l.State, C.lua_Number(C.longlong(token.Value.(int64))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(int64)))
 
 		case sb.KindUint:
-			C.lua_pushnumber(l.State, C.lua_Number(C.ulonglong(token.Value.(uint))))
+			if err := pushUnsigned(l, uint64(token.Value.(uint))); err != nil {
+				return nil, err
+			}
 		case sb.KindUint8:
-			C.lua_pushnumber(l.State, C.lua_Number(C.ulonglong(token.Value.(uint8))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(uint8)))
 		case sb.KindUint16:
-			C.lua_pushnumber(l.State, C.lua_Number(C.ulonglong(token.Value.(uint16))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(uint16)))
 		case sb.KindUint32:
-			C.lua_pushnumber(l.State, C.lua_Number(C.ulonglong(token.Value.(uint32))))
+			C.lua_pushinteger(l.State, C.lua_Integer(token.Value.(uint32)))
 		case sb.KindUint64:
-			C.lua_pushnumber(l.State, C.lua_Number(C.ulonglong(token.Value.(uint64))))
+			if err := pushUnsigned(l, uint64(token.Value.(uint64))); err != nil {
+				return nil, err
+			}
 
 		case sb.KindFloat32:
 			C.lua_pushnumber(l.State, C.lua_Number(C.double(token.Value.(float32))))
@@ -78,6 +202,9 @@ func pushValue(l *Lua, cont sink) sink {
 		case sb.KindPointer:
 			C.lua_pushlightuserdata(l.State, unsafe.Pointer(token.Value.(uintptr)))
 
+		case kindValue:
+			l.pushGoValue(token.Value.(reflect.Value))
+
 		default:
 			l.Panic("invalid value: %s", token)
 		}
@@ -86,6 +213,18 @@ func pushValue(l *Lua, cont sink) sink {
 	}
 }
 
+// pushUnsigned pushes n as an integer, numbers beyond the lua integer range are errors
+func pushUnsigned(l *Lua, n uint64) error {
+	if n > math.MaxInt64 {
+		return &LuaError{
+			Kind:    ErrTypeMismatch,
+			Message: fmt.Sprintf("number %d overflows lua integer", n),
+		}
+	}
+	C.lua_pushinteger(l.State, C.lua_Integer(n))
+	return nil
+}
+
 func pushArray(l *Lua, num int, cont sink) sink {
 	return func(token *sb.Token) (sink, error) {
 		if token == nil { // NOCOVER
@@ -94,7 +233,7 @@ func pushArray(l *Lua, num int, cont sink) sink {
 		if token.Kind == sb.KindArrayEnd {
 			return cont, nil
 		}
-		C.lua_pushnumber(l.State, C.lua_Number(num))
+		C.lua_pushinteger(l.State, C.lua_Integer(num))
 		return pushValue(
 			l,
 			func(token *sb.Token) (sink, error) {
@@ -150,3 +289,15 @@ func pushObject(l *Lua, cont sink) sink {
 		).Sink(token)
 	}
 }
+
+func (l *Lua) pushString(str string) {
+	cStr := C.CString(str)
+	C.lua_pushlstring(l.State, cStr, C.size_t(len(str)))
+	C.free(unsafe.Pointer(cStr))
+}
+
+func (l *Lua) pushBytes(bs []byte) {
+	p := C.CBytes(bs)
+	C.lua_pushlstring(l.State, (*C.char)(p), C.size_t(len(bs)))
+	C.free(p)
+}
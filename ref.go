@@ -0,0 +1,118 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+
+void push_table_op(lua_State*, int);
+*/
+import "C"
+
+import (
+	"reflect"
+	"runtime"
+)
+
+// Ref is a reference to a lua value kept in the registry.
+// A Ref is obtained by GlobalRef, or by decoding any lua value into a *Ref,
+// as a call result or an argument of a go function.
+// The reference is kept until Release is called or the Ref is garbage collected.
+type Ref struct {
+	lua *Lua
+	ref C.int
+}
+
+var (
+	refType    = reflect.TypeOf(Ref{})
+	refPtrType = reflect.PtrTo(refType)
+)
+
+// newRef references the value at num
+func (l *Lua) newRef(num C.int) *Ref {
+	C.lua_pushvalue(l.State, num)
+	ref := &Ref{
+		lua: l,
+		ref: C.luaL_ref(l.State, C.LUA_REGISTRYINDEX),
+	}
+	runtime.SetFinalizer(ref, func(ref *Ref) {
+		ref.lua.releaseLater(ref.ref)
+	})
+	return ref
+}
+
+// GlobalRef returns a reference to the value of global name
+func (l *Lua) GlobalRef(name string) *Ref {
+	l.checkOpen()
+	C.lua_getglobal(l.State, cstr(name))
+	defer C.lua_settop(l.State, -2)
+	return l.newRef(-1)
+}
+
+func (r *Ref) check() {
+	r.lua.checkOpen()
+	if r.ref == C.LUA_NOREF {
+		r.lua.Panic("ref released")
+	}
+}
+
+// Push pushes the referenced value onto the stack
+func (r *Ref) Push() {
+	r.check()
+	C.lua_rawgeti(r.lua.State, C.LUA_REGISTRYINDEX, C.lua_Integer(r.ref))
+}
+
+// Type returns the lua type of the referenced value
+func (r *Ref) Type() LuaType {
+	r.Push()
+	defer C.lua_settop(r.lua.State, -2)
+	return LuaType(C.lua_type(r.lua.State, -1))
+}
+
+// Call calls the referenced value with args and decodes the return values into rets, like Lua.Call
+func (r *Ref) Call(args []interface{}, rets ...interface{}) error {
+	r.check()
+	return r.lua.call(r.Push, args, rets)
+}
+
+// Get decodes the value indexed by key into target, metamethods are respected
+func (r *Ref) Get(key interface{}, target interface{}) error {
+	r.check()
+	l := r.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 3)
+	C.push_table_op(l.State, tableGet)
+	r.Push()
+	l.push(key)
+	if ret := l.pcall(2, 1, 0); ret != C.int(0) {
+		return l.newError(ret)
+	}
+	return l.decode(C.lua_gettop(l.State), target)
+}
+
+// Equal reports whether two references refer to the same lua value, without calling metamethods
+func (r *Ref) Equal(other *Ref) bool {
+	r.check()
+	other.check()
+	if r.lua != other.lua {
+		return false
+	}
+	l := r.lua
+	r.Push()
+	other.Push()
+	defer C.lua_settop(l.State, -3)
+	return C.lua_rawequal(l.State, -1, -2) == 1
+}
+
+// Release releases the reference. Calling Release more than once is a no-op.
+func (r *Ref) Release() {
+	if r.ref == C.LUA_NOREF {
+		return
+	}
+	runtime.SetFinalizer(r, nil)
+	if r.lua.State != nil {
+		C.luaL_unref(r.lua.State, C.LUA_REGISTRYINDEX, r.ref)
+	}
+	r.ref = C.LUA_NOREF
+}
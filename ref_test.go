@@ -0,0 +1,138 @@
+package lgo
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRef(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		config = {
+			name = 'foo',
+			handlers = {},
+		}
+		function add(a, b)
+			return a + b
+		end
+		function getConfig()
+			return config
+		end
+	`)
+
+	t.Run("global", func(t *testing.T) {
+		ref := lua.GlobalRef("config")
+		defer ref.Release()
+		if ref.Type() != TypeTable {
+			t.Fatal()
+		}
+		var name string
+		if err := ref.Get("name", &name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "foo" {
+			t.Fatal()
+		}
+		lua.RunString(`config.name = 'bar'`)
+		if err := ref.Get("name", &name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "bar" {
+			t.Fatal()
+		}
+	})
+
+	t.Run("call", func(t *testing.T) {
+		ref := lua.GlobalRef("add")
+		defer ref.Release()
+		var i int
+		if err := ref.Call([]interface{}{1, 2}, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != 3 {
+			t.Fatal()
+		}
+		lua.RunString(`add = nil`)
+		if err := ref.Call([]interface{}{3, 4}, &i); err != nil {
+			t.Fatal(err)
+		}
+		if i != 7 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("call result", func(t *testing.T) {
+		var ref *Ref
+		if err := lua.Call("getConfig", nil, &ref); err != nil {
+			t.Fatal(err)
+		}
+		defer ref.Release()
+		global := lua.GlobalRef("config")
+		defer global.Release()
+		if !ref.Equal(global) {
+			t.Fatal()
+		}
+		other := lua.GlobalRef("getConfig")
+		defer other.Release()
+		if ref.Equal(other) {
+			t.Fatal()
+		}
+	})
+
+	t.Run("function argument", func(t *testing.T) {
+		var handler *Ref
+		lua.RegisterFunction("setHandler", func(ref *Ref) {
+			handler = ref
+		})
+		lua.RunString(`setHandler(function(s) return s .. '!' end)`)
+		var s string
+		if err := handler.Call([]interface{}{"foo"}, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "foo!" {
+			t.Fatal()
+		}
+		// push back
+		lua.RunString(`function apply(fn, arg) return fn(arg) end`)
+		if err := lua.Call("apply", []interface{}{handler, "bar"}, &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "bar!" {
+			t.Fatal()
+		}
+		handler.Release()
+		handler.Release()
+		func() {
+			defer func() {
+				p := recover()
+				if p == nil {
+					t.Fatal()
+				}
+				if p.(string) != "ref released" {
+					t.Fatal()
+				}
+			}()
+			handler.Push()
+		}()
+	})
+
+	t.Run("index error", func(t *testing.T) {
+		ref := lua.GlobalRef("config")
+		defer ref.Release()
+		var s string
+		err := ref.Get("name", &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		num := lua.GlobalRef("undefinedGlobal")
+		defer num.Release()
+		if num.Type() != TypeNil {
+			t.Fatal()
+		}
+		if err := num.Get("foo", &s); !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
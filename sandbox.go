@@ -0,0 +1,66 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+#include <stdint.h>
+
+int open_sandbox_lib(lua_State*, const char*);
+void setup_sandbox(lua_State*, int64_t);
+*/
+import "C"
+
+import "fmt"
+
+// SandboxLibs are the standard libraries a sandbox may open
+var SandboxLibs = []string{"base", "string", "table", "math", "utf8", "coroutine"}
+
+// SandboxOptions configures a Lua created by NewSandbox
+type SandboxOptions struct {
+	Options
+	// Libs are the libraries to open, a subset of SandboxLibs. All of them are opened if nil.
+	Libs []string
+	// Print is called with the arguments of print converted by tostring and joined by tabs.
+	// Printed lines are discarded if nil.
+	Print func(line string)
+}
+
+// NewSandbox creates a Lua for untrusted code, like NewWithOptions but opening only the libraries in options.Libs.
+// dofile and loadfile are removed, load and the RunString methods only accept text chunks,
+// collectgarbage only accepts the "collect", "count", "step" and "isrunning" options,
+// setmetatable rejects metatables with __gc, and print is routed to options.Print.
+// Finalizers are rejected since they may run outside calls, where Limits and contexts are not checked.
+func NewSandbox(options SandboxOptions) *Lua {
+	libs := options.Libs
+	if libs == nil {
+		libs = SandboxLibs
+	}
+	for _, lib := range libs {
+		if !isSandboxLib(lib) {
+			panic(fmt.Sprintf("library %s is not allowed in sandbox", lib))
+		}
+	}
+	lua := newWithOptions(options.Options, func(state *C.lua_State) {
+		for _, lib := range libs {
+			C.open_sandbox_lib(state, cstr(lib))
+		}
+	})
+	lua.sandbox = true
+	print := options.Print
+	if print == nil {
+		print = func(string) {}
+	}
+	handle := lua.newHandle(lua.newFunction("print", print))
+	C.setup_sandbox(lua.State, C.int64_t(handle))
+	return lua
+}
+
+func isSandboxLib(name string) bool {
+	for _, lib := range SandboxLibs {
+		if lib == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package lgo
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	var lines []string
+	lua := NewSandbox(SandboxOptions{
+		Print: func(line string) {
+			lines = append(lines, line)
+		},
+	})
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("libs", func(t *testing.T) {
+		lua.RunString(`
+			assert(os == nil)
+			assert(io == nil)
+			assert(debug == nil)
+			assert(package == nil)
+			assert(require == nil)
+			assert(dofile == nil)
+			assert(loadfile == nil)
+			assert(string.upper('a') == 'A')
+			assert(table.concat({1, 2}, ',') == '1,2')
+			assert(math.max(1, 2) == 2)
+			assert(utf8.char(65) == 'A')
+			assert(coroutine.wrap(function() return 1 end)() == 1)
+		`)
+	})
+
+	t.Run("print", func(t *testing.T) {
+		lua.RunString(`
+			print('foo', 1, nil, setmetatable({}, {__tostring = function() return 'bar' end}))
+			print()
+			coroutine.wrap(function()
+				print('baz', 2)
+			end)()
+		`)
+		if len(lines) != 3 || lines[0] != "foo\t1\tnil\tbar" || lines[1] != "" || lines[2] != "baz\t2" {
+			t.Fatalf("got %q", lines)
+		}
+	})
+
+	t.Run("load", func(t *testing.T) {
+		lua.RunString(`
+			assert(load('return 1')() == 1)
+			assert(load('return x', 'chunk', 'b', {x = 2})() == 2)
+			local f, err = load(string.dump(function() end))
+			assert(f == nil)
+			assert(err:find('binary'))
+		`)
+
+		lua.RunString(`dumped = string.dump(function() end)`)
+		var dumped string
+		if err := lua.GetGlobal("dumped", &dumped); err != nil {
+			t.Fatal(err)
+		}
+		if err := lua.RunStringE(dumped); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("got %v", err)
+		}
+		env := lua.NewEnv()
+		defer env.Release()
+		if err := lua.RunStringIn(env, dumped); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("collectgarbage", func(t *testing.T) {
+		lua.RunString(`
+			collectgarbage()
+			assert(collectgarbage('count') > 0)
+		`)
+		err := lua.RunStringE(`collectgarbage('stop')`)
+		if !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("finalizers", func(t *testing.T) {
+		err := lua.RunStringE(`
+			setmetatable({}, {__gc = function() while true do end end})
+		`)
+		if !errors.Is(err, ErrRuntime) || !strings.Contains(err.Error(), "__gc") {
+			t.Fatalf("got %v", err)
+		}
+		lua.RunString(`
+			local t = setmetatable({}, {__index = {x = 1}})
+			assert(t.x == 1)
+		`)
+	})
+
+	t.Run("traceback", func(t *testing.T) {
+		err := lua.RunStringE(`
+			local function foo()
+				error('foo')
+			end
+			foo()
+		`)
+		var luaErr *LuaError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("got %v", err)
+		}
+		if !strings.Contains(luaErr.Traceback, "stack traceback") ||
+			!strings.Contains(luaErr.Traceback, "foo") {
+			t.Fatalf("got %s", luaErr.Traceback)
+		}
+	})
+
+	t.Run("select libs", func(t *testing.T) {
+		lua := NewSandbox(SandboxOptions{
+			Libs: []string{"base", "string"},
+		})
+		defer lua.Close()
+		lua.RunString(`
+			assert(table == nil)
+			assert(math == nil)
+			assert(string.rep('a', 2) == 'aa')
+			print('discarded')
+		`)
+
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Fatal()
+				}
+			}()
+			NewSandbox(SandboxOptions{
+				Libs: []string{"os"},
+			})
+		}()
+	})
+
+	t.Run("memory limit", func(t *testing.T) {
+		lua := NewSandbox(SandboxOptions{
+			Options: Options{
+				MemoryLimit: 1 << 20,
+			},
+		})
+		defer lua.Close()
+		lua.PrintTraceback = false
+		err := lua.RunStringE(`string.rep('x', 2 << 20)`)
+		if !errors.Is(err, ErrMemory) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package lgo
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structField is a struct field mapped to a lua key
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields is the field mapping of a struct type.
+// Fields are named by lua tags, or json tags if enabled, or go names.
+// Fields tagged "-" and unexported fields are skipped,
+// fields of untagged embedded structs are inlined with the go rules of promotion.
+type structFields struct {
+	list   []*structField
+	byName map[string]*structField
+	// lower cased names, for case insensitive matching
+	byFoldedName map[string]*structField
+}
+
+type structFieldsKey struct {
+	t        reflect.Type
+	jsonTags bool
+}
+
+var structFieldsMap sync.Map
+
+func (l *Lua) structFields(t reflect.Type) *structFields {
+	key := structFieldsKey{
+		t:        t,
+		jsonTags: l.JSONTags,
+	}
+	if v, ok := structFieldsMap.Load(key); ok {
+		return v.(*structFields)
+	}
+
+	type candidate struct {
+		structField
+		tagged bool
+	}
+	var candidates []*candidate
+	var collect func(t reflect.Type, index []int)
+	collect = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, ok := field.Tag.Lookup("lua")
+			if !ok && l.JSONTags {
+				tag = field.Tag.Get("json")
+			}
+			if tag == "-" {
+				continue
+			}
+			name, options := tag, ""
+			if i := strings.Index(tag, ","); i >= 0 {
+				name, options = tag[:i], tag[i+1:]
+			}
+			fieldIndex := append(index[:len(index):len(index)], i)
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				collect(field.Type, fieldIndex)
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			c := &candidate{
+				structField: structField{
+					name:  name,
+					index: fieldIndex,
+				},
+				tagged: name != "",
+			}
+			if c.name == "" {
+				c.name = field.Name
+			}
+			for _, option := range strings.Split(options, ",") {
+				if option == "omitempty" {
+					c.omitEmpty = true
+				}
+			}
+			candidates = append(candidates, c)
+		}
+	}
+	collect(t, nil)
+
+	// the shallowest field wins, a tagged one wins among fields of the same depth, others are ambiguous
+	byName := make(map[string][]*candidate)
+	for _, c := range candidates {
+		byName[c.name] = append(byName[c.name], c)
+	}
+	fields := &structFields{
+		byName:       make(map[string]*structField),
+		byFoldedName: make(map[string]*structField),
+	}
+	dominants := make(map[string]*candidate)
+	for name, cs := range byName {
+		depth := len(cs[0].index)
+		tagged := false
+		for _, c := range cs {
+			if len(c.index) < depth {
+				depth = len(c.index)
+				tagged = false
+			}
+			if len(c.index) == depth && c.tagged {
+				tagged = true
+			}
+		}
+		var dominant []*candidate
+		for _, c := range cs {
+			if len(c.index) == depth && (c.tagged || !tagged) {
+				dominant = append(dominant, c)
+			}
+		}
+		if len(dominant) == 1 {
+			dominants[name] = dominant[0]
+		}
+	}
+	for _, c := range candidates {
+		if dominants[c.name] != c {
+			continue
+		}
+		fields.list = append(fields.list, &c.structField)
+		fields.byName[c.name] = &c.structField
+		folded := strings.ToLower(c.name)
+		if _, ok := fields.byFoldedName[folded]; !ok {
+			fields.byFoldedName[folded] = &c.structField
+		}
+	}
+
+	structFieldsMap.Store(key, fields)
+	return fields
+}
+
+// lookupField returns the field of name, matching case insensitively if l.CaseInsensitiveFields is set
+func (l *Lua) lookupField(fields *structFields, name string) *structField {
+	if field, ok := fields.byName[name]; ok {
+		return field
+	}
+	if l.CaseInsensitiveFields {
+		return fields.byFoldedName[strings.ToLower(name)]
+	}
+	return nil
+}
+
+func isEmptyValue(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
@@ -0,0 +1,185 @@
+package lgo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testBase struct {
+	ID      int    `lua:"id"`
+	Comment string `lua:"comment,omitempty"`
+}
+
+type testItem struct {
+	testBase
+	ItemName  string   `lua:"item_name"`
+	UnitPrice float64  `lua:"unit_price"`
+	Secret    string   `lua:"-"`
+	Tags      []string `lua:"tags,omitempty"`
+	Count     int
+	internal  int
+}
+
+func TestStructTags(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+
+	t.Run("push", func(t *testing.T) {
+		lua.SetGlobal("item", testItem{
+			testBase: testBase{
+				ID: 1,
+			},
+			ItemName:  "foo",
+			UnitPrice: 4.5,
+			Secret:    "secret",
+			Count:     3,
+		})
+		lua.RunString(`
+			assert(item.id == 1)
+			assert(item.item_name == 'foo')
+			assert(item.unit_price == 4.5)
+			assert(item.Count == 3)
+			assert(item.comment == nil)
+			assert(item.tags == nil)
+			assert(item.Secret == nil)
+			assert(item.ItemName == nil)
+			assert(item.testBase == nil)
+			assert(item.internal == nil)
+		`)
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		lua.RunString(`
+			item = {
+				id = 2,
+				comment = 'bar',
+				item_name = 'foo',
+				unit_price = 1.5,
+				tags = {'a', 'b'},
+				Count = 3,
+			}
+		`)
+		var item testItem
+		if err := lua.GetGlobal("item", &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != 2 ||
+			item.Comment != "bar" ||
+			item.ItemName != "foo" ||
+			item.UnitPrice != 1.5 ||
+			len(item.Tags) != 2 ||
+			item.Count != 3 {
+			t.Fatalf("got %+v", item)
+		}
+
+		lua.RunString(`item = { Secret = 'foo' }`)
+		err := lua.GetGlobal("item", &item)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		lua.RunString(`item = { [1] = 'foo' }`)
+		err = lua.GetGlobal("item", &item)
+		if !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		lua.RunString(`item = { ITEM_NAME = 'foo', count = 1 }`)
+		var item testItem
+		if err := lua.GetGlobal("item", &item); err == nil {
+			t.Fatal()
+		}
+		lua.CaseInsensitiveFields = true
+		defer func() {
+			lua.CaseInsensitiveFields = false
+		}()
+		if err := lua.GetGlobal("item", &item); err != nil {
+			t.Fatal(err)
+		}
+		if item.ItemName != "foo" || item.Count != 1 {
+			t.Fatalf("got %+v", item)
+		}
+	})
+
+	t.Run("json tags", func(t *testing.T) {
+		type Config struct {
+			Name    string `json:"name"`
+			Address string `json:"addr" lua:"address"`
+			Skip    string `json:"-"`
+		}
+		lua.SetGlobal("config", Config{
+			Name:    "foo",
+			Address: "bar",
+			Skip:    "baz",
+		})
+		lua.RunString(`
+			assert(config.Name == 'foo')
+			assert(config.address == 'bar')
+			assert(config.Skip == 'baz')
+		`)
+		lua.JSONTags = true
+		defer func() {
+			lua.JSONTags = false
+		}()
+		lua.SetGlobal("config", Config{
+			Name:    "foo",
+			Address: "bar",
+			Skip:    "baz",
+		})
+		lua.RunString(`
+			assert(config.name == 'foo')
+			assert(config.address == 'bar')
+			assert(config.Skip == nil)
+		`)
+		var config Config
+		if err := lua.GetGlobal("config", &config); err != nil {
+			t.Fatal(err)
+		}
+		if config.Name != "foo" || config.Address != "bar" {
+			t.Fatalf("got %+v", config)
+		}
+	})
+
+	t.Run("embedding", func(t *testing.T) {
+		type A struct {
+			X int
+			Y int
+		}
+		type B struct {
+			X int
+			Z int `lua:"y"`
+		}
+		type C struct {
+			A
+			B
+			Y int
+		}
+		fields := lua.structFields(reflect.TypeOf(C{}))
+		var names []string
+		for _, field := range fields.list {
+			names = append(names, field.name)
+		}
+		// X is ambiguous
+		if len(names) != 2 || names[0] != "y" || names[1] != "Y" {
+			t.Fatalf("got %v", names)
+		}
+	})
+
+	t.Run("objects", func(t *testing.T) {
+		item := &testItem{
+			ItemName: "foo",
+		}
+		lua.SetGlobalObject("obj", item)
+		lua.RunString(`
+			assert(obj.item_name == 'foo')
+			obj.unit_price = 2
+			obj.id = 3
+		`)
+		if item.UnitPrice != 2 || item.ID != 3 {
+			t.Fatalf("got %+v", item)
+		}
+	})
+}
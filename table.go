@@ -0,0 +1,233 @@
+package lgo
+
+/*
+#include <lua.h>
+#include <lualib.h>
+#include <lauxlib.h>
+
+void push_table_op(lua_State*, int);
+*/
+import "C"
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// operations of push_table_op
+const (
+	tableGet C.int = iota
+	tableSet
+	tableRawSet
+	tableLen
+)
+
+// Table is a reference to a lua table.
+// A Table is obtained by NewTable, or by decoding a lua table into a *Table.
+// Get, Set and Len respect metamethods, the Raw variants and other methods do not.
+type Table struct {
+	*Ref
+}
+
+var (
+	tableType    = reflect.TypeOf(Table{})
+	tablePtrType = reflect.PtrTo(tableType)
+)
+
+// NewTable creates an empty lua table
+func (l *Lua) NewTable() *Table {
+	l.checkOpen()
+	C.lua_createtable(l.State, 0, 0)
+	defer C.lua_settop(l.State, -2)
+	return &Table{
+		Ref: l.newRef(-1),
+	}
+}
+
+// Set sets the value indexed by key, metamethods are respected
+func (t *Table) Set(key interface{}, value interface{}) error {
+	return t.set(tableSet, key, value)
+}
+
+// RawSet sets the value indexed by key without calling metamethods
+func (t *Table) RawSet(key interface{}, value interface{}) error {
+	return t.set(tableRawSet, key, value)
+}
+
+func (t *Table) set(op C.int, key interface{}, value interface{}) error {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 4)
+	C.push_table_op(l.State, op)
+	t.Push()
+	l.push(key)
+	l.push(value)
+	if ret := l.pcall(3, 0, 0); ret != C.int(0) {
+		return l.newError(ret)
+	}
+	return nil
+}
+
+// RawGet decodes the value indexed by key into target, without calling metamethods
+func (t *Table) RawGet(key interface{}, target interface{}) error {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 2)
+	t.Push()
+	l.push(key)
+	C.lua_rawget(l.State, -2)
+	return l.decode(C.lua_gettop(l.State), target)
+}
+
+// Len returns the length of the table, as the # operator
+func (t *Table) Len() (n int, err error) {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 2)
+	C.push_table_op(l.State, tableLen)
+	t.Push()
+	if ret := l.pcall(1, 1, 0); ret != C.int(0) {
+		return 0, l.newError(ret)
+	}
+	err = l.decode(C.lua_gettop(l.State), &n)
+	return
+}
+
+// Append appends values to the sequence part of the table
+func (t *Table) Append(values ...interface{}) {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 2)
+	t.Push()
+	n := C.lua_rawlen(l.State, -1)
+	for i, value := range values {
+		l.push(value)
+		C.lua_rawseti(l.State, -2, C.lua_Integer(n)+C.lua_Integer(i)+1)
+	}
+}
+
+// each calls fn for each pair of the table, with the key at -2 and the value at -1
+func (t *Table) each(fn func() (bool, error)) error {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 4)
+	t.Push()
+	num := C.lua_gettop(l.State)
+	C.lua_pushnil(l.State)
+	for C.lua_next(l.State, num) != 0 {
+		cont, err := fn()
+		if err != nil {
+			return err
+		}
+		if !cont {
+			break
+		}
+		C.lua_settop(l.State, num+1)
+	}
+	return nil
+}
+
+// ForEach calls fn for each key-value pair of the table.
+// fn must be a function of two parameters, keys and values are decoded into the parameter types.
+// fn may return a bool, iteration stops when it returns false, or an error, which stops iteration and is returned.
+// fn must not add new keys to the table.
+func (t *Table) ForEach(fn interface{}) error {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func ||
+		fnType.NumIn() != 2 ||
+		fnType.NumOut() > 1 ||
+		fnType.NumOut() == 1 && fnType.Out(0) != boolType && fnType.Out(0) != errorType {
+		t.lua.Panic("bad ForEach function: %T", fn)
+	}
+	l := t.lua
+	return t.each(func() (bool, error) {
+		key := reflect.New(fnType.In(0))
+		if err := l.decode(C.lua_absindex(l.State, -2), key.Interface()); err != nil {
+			return false, err
+		}
+		value := reflect.New(fnType.In(1))
+		if err := l.decode(C.lua_absindex(l.State, -1), value.Interface()); err != nil {
+			return false, err
+		}
+		rets := fnValue.Call([]reflect.Value{key.Elem(), value.Elem()})
+		if len(rets) == 0 {
+			return true, nil
+		}
+		switch ret := rets[0].Interface().(type) {
+		case bool:
+			return ret, nil
+		case error:
+			return false, ret
+		}
+		return true, nil
+	})
+}
+
+// Keys decodes the keys of the table into target, which must be a pointer to a slice
+func (t *Table) Keys(target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Slice {
+		return &LuaError{
+			Kind:    ErrTypeMismatch,
+			Message: fmt.Sprintf("bad keys target: %T", target),
+		}
+	}
+	slice := ptr.Elem()
+	l := t.lua
+	keys := reflect.MakeSlice(slice.Type(), 0, 0)
+	if err := t.each(func() (bool, error) {
+		key := reflect.New(slice.Type().Elem())
+		if err := l.decode(C.lua_absindex(l.State, -2), key.Interface()); err != nil {
+			return false, err
+		}
+		keys = reflect.Append(keys, key.Elem())
+		return true, nil
+	}); err != nil {
+		return err
+	}
+	slice.Set(keys)
+	return nil
+}
+
+// Metatable returns the metatable of the table, or nil if it has none
+func (t *Table) Metatable() *Table {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 2)
+	t.Push()
+	if C.lua_getmetatable(l.State, -1) == 0 {
+		return nil
+	}
+	return &Table{
+		Ref: l.newRef(-1),
+	}
+}
+
+// SetMetatable sets the metatable of the table, a nil mt removes the metatable
+func (t *Table) SetMetatable(mt *Table) {
+	t.check()
+	l := t.lua
+	top := C.lua_gettop(l.State)
+	defer C.lua_settop(l.State, top)
+	C.lua_checkstack(l.State, 2)
+	t.Push()
+	if mt == nil {
+		C.lua_pushnil(l.State)
+	} else {
+		l.pushGoValue(reflect.ValueOf(mt))
+	}
+	C.lua_setmetatable(l.State, -2)
+}
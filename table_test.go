@@ -0,0 +1,271 @@
+package lgo
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	lua := New()
+	defer lua.Close()
+	lua.PrintTraceback = false
+	lua.RunString(`
+		config = {
+			name = 'foo',
+			port = 80,
+			handler = function() end,
+		}
+		function getConfig()
+			return config
+		end
+		proxy = setmetatable({}, {
+			__index = function(t, k)
+				return k .. '!'
+			end,
+			__newindex = function(t, k, v)
+				error('read only')
+			end,
+			__len = function()
+				return 42
+			end,
+		})
+		function getProxy()
+			return proxy
+		end
+	`)
+
+	t.Run("get set", func(t *testing.T) {
+		var config *Table
+		if err := lua.Call("getConfig", nil, &config); err != nil {
+			t.Fatal(err)
+		}
+		defer config.Release()
+		var port int
+		if err := config.Get("port", &port); err != nil {
+			t.Fatal(err)
+		}
+		if port != 80 {
+			t.Fatal()
+		}
+		if err := config.Set("port", 8080); err != nil {
+			t.Fatal(err)
+		}
+		if err := config.RawGet("port", &port); err != nil {
+			t.Fatal(err)
+		}
+		if port != 8080 {
+			t.Fatal()
+		}
+		var name int
+		if err := config.Get("name", &name); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		if err := config.Set(nil, 1); !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("metamethods", func(t *testing.T) {
+		var proxy *Table
+		if err := lua.Call("getProxy", nil, &proxy); err != nil {
+			t.Fatal(err)
+		}
+		defer proxy.Release()
+		var s string
+		if err := proxy.Get("foo", &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "foo!" {
+			t.Fatal()
+		}
+		var v interface{}
+		if err := proxy.RawGet("foo", &v); err != nil {
+			t.Fatal(err)
+		}
+		if v != nil {
+			t.Fatal()
+		}
+		if err := proxy.Set("foo", 1); !errors.Is(err, ErrRuntime) {
+			t.Fatalf("got %v", err)
+		}
+		if err := proxy.RawSet("foo", "bar"); err != nil {
+			t.Fatal(err)
+		}
+		if err := proxy.Get("foo", &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "bar" {
+			t.Fatal()
+		}
+		n, err := proxy.Len()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 42 {
+			t.Fatal()
+		}
+		mt := proxy.Metatable()
+		if mt == nil {
+			t.Fatal()
+		}
+		proxy.SetMetatable(nil)
+		if proxy.Metatable() != nil {
+			t.Fatal()
+		}
+		n, err = proxy.Len()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Fatal()
+		}
+		proxy.SetMetatable(mt)
+		if err := proxy.Get("bar", &s); err != nil {
+			t.Fatal(err)
+		}
+		if s != "bar!" {
+			t.Fatal()
+		}
+	})
+
+	t.Run("new table", func(t *testing.T) {
+		table := lua.NewTable()
+		defer table.Release()
+		table.Append(1, 2, 3)
+		table.Append("foo")
+		n, err := table.Len()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 4 {
+			t.Fatal()
+		}
+		lua.RunString(`
+			function sum(t)
+				local ret = 0
+				for i = 1, 3 do
+					ret = ret + t[i]
+				end
+				return ret
+			end
+		`)
+		var sum int
+		if err := lua.Call("sum", []interface{}{table}, &sum); err != nil {
+			t.Fatal(err)
+		}
+		if sum != 6 {
+			t.Fatal()
+		}
+	})
+
+	t.Run("iterate", func(t *testing.T) {
+		var config *Table
+		if err := lua.Call("getConfig", nil, &config); err != nil {
+			t.Fatal(err)
+		}
+		defer config.Release()
+
+		var keys []string
+		if err := config.Keys(&keys); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(keys)
+		if len(keys) != 3 ||
+			keys[0] != "handler" ||
+			keys[1] != "name" ||
+			keys[2] != "port" {
+			t.Fatalf("got %v", keys)
+		}
+
+		// function values
+		types := make(map[string]LuaType)
+		if err := config.ForEach(func(key string, value *Ref) {
+			types[key] = value.Type()
+			value.Release()
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if types["handler"] != TypeFunction ||
+			types["name"] != TypeString ||
+			types["port"] != TypeNumber {
+			t.Fatalf("got %v", types)
+		}
+
+		// functions decoded into interface{} as *Ref
+		values := make(map[string]interface{})
+		if err := config.ForEach(func(key string, value interface{}) {
+			values[key] = value
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if ref, ok := values["handler"].(*Ref); !ok || ref.Type() != TypeFunction {
+			t.Fatalf("got %#v", values["handler"])
+		}
+
+		// stop
+		n := 0
+		if err := config.ForEach(func(key string, value *Ref) bool {
+			n++
+			value.Release()
+			return false
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatal()
+		}
+
+		// error
+		e := errors.New("foo")
+		if err := config.ForEach(func(key string, value *Ref) error {
+			value.Release()
+			return e
+		}); err != e {
+			t.Fatal()
+		}
+
+		// decode error
+		table := lua.NewTable()
+		defer table.Release()
+		table.Set("foo", "bar")
+		if err := table.ForEach(func(key string, value int) {
+		}); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		var ints []int
+		if err := table.Keys(&ints); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+		if err := config.Keys(ints); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+
+		func() {
+			defer func() {
+				if p := recover(); p == nil {
+					t.Fatal()
+				}
+			}()
+			config.ForEach(func() {})
+		}()
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		var table *Table
+		if err := lua.Call("getConfig", nil, &table); err != nil {
+			t.Fatal(err)
+		}
+		table.Release()
+		lua.RunString(`function getNil() end function getNumber() return 1 end`)
+		if err := lua.Call("getNil", nil, &table); err != nil {
+			t.Fatal(err)
+		}
+		if table != nil {
+			t.Fatal()
+		}
+		if err := lua.Call("getNumber", nil, &table); !errors.Is(err, ErrTypeMismatch) {
+			t.Fatalf("got %v", err)
+		}
+	})
+}